@@ -0,0 +1,122 @@
+package esworker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	defaultAdaptiveTargetLatency = time.Duration(1 * time.Second)
+	defaultAdaptiveTargetBytes   = 5 * 1024 * 1024
+	adaptiveSteps                = 10 // bounds are divided into it to decide an additive step.
+)
+
+// batchController adjusts a batch size and a flush interval of a worker in AIMD style.
+// a bulk which is slow, rejected by 429 or larger than target bytes halves a batch size and doubles an interval,
+// otherwise a batch size grows and an interval shrinks by a step.
+type batchController struct {
+	sync.Mutex
+	size          int
+	minSize       int
+	maxSize       int
+	sizeStep      int
+	interval      time.Duration
+	minInterval   time.Duration
+	maxInterval   time.Duration
+	intervalStep  time.Duration
+	targetLatency time.Duration
+	targetBytes   int // bytes of a bulk are not regarded if it is 0.
+}
+
+// batchSize returns a current batch size.
+func (bc *batchController) batchSize() int {
+	bc.Lock()
+	defer bc.Unlock()
+	return bc.size
+}
+
+// flushInterval returns a current flush interval.
+func (bc *batchController) flushInterval() time.Duration {
+	bc.Lock()
+	defer bc.Unlock()
+	return bc.interval
+}
+
+// observe adjusts a batch size and a flush interval with a result of a bulk.
+// a batch size only grows if the bulk was filled up to it.
+func (bc *batchController) observe(count int, latency time.Duration, bytes int, rejected bool) {
+	if bc == nil {
+		return
+	}
+
+	bc.Lock()
+	defer bc.Unlock()
+	congested := rejected || latency > bc.targetLatency || (bc.targetBytes > 0 && bytes > bc.targetBytes)
+	if congested {
+		bc.size /= 2
+		if bc.size < bc.minSize {
+			bc.size = bc.minSize
+		}
+		bc.interval *= 2
+		if bc.interval > bc.maxInterval {
+			bc.interval = bc.maxInterval
+		}
+		return
+	}
+
+	if count >= bc.size {
+		bc.size += bc.sizeStep
+		if bc.size > bc.maxSize {
+			bc.size = bc.maxSize
+		}
+	}
+	bc.interval -= bc.intervalStep
+	if bc.interval < bc.minInterval {
+		bc.interval = bc.minInterval
+	}
+}
+
+// createBatchController is to make batchController, and it returns nil if an adaptive mode is disabled.
+// it starts from a worker queue size and a wait interval within bounds.
+func createBatchController(cfg *config) (*batchController, error) {
+	if !cfg.adaptive {
+		return nil, nil
+	}
+	if cfg.adaptiveMinSize <= 0 || cfg.adaptiveMaxSize < cfg.adaptiveMinSize ||
+		cfg.adaptiveMinInterval <= 0 || cfg.adaptiveMaxInterval < cfg.adaptiveMinInterval {
+		return nil, fmt.Errorf("[err] createBatchController (invalid bounds)")
+	}
+
+	bc := &batchController{
+		size:          cfg.workerQueueSize,
+		minSize:       cfg.adaptiveMinSize,
+		maxSize:       cfg.adaptiveMaxSize,
+		sizeStep:      (cfg.adaptiveMaxSize - cfg.adaptiveMinSize) / adaptiveSteps,
+		interval:      cfg.workerWaitInterval,
+		minInterval:   cfg.adaptiveMinInterval,
+		maxInterval:   cfg.adaptiveMaxInterval,
+		intervalStep:  (cfg.adaptiveMaxInterval - cfg.adaptiveMinInterval) / time.Duration(adaptiveSteps),
+		targetLatency: cfg.adaptiveTargetLatency,
+		targetBytes:   cfg.adaptiveTargetBytes,
+	}
+	if bc.sizeStep < 1 {
+		bc.sizeStep = 1
+	}
+	if bc.targetLatency <= 0 {
+		bc.targetLatency = defaultAdaptiveTargetLatency
+	}
+	switch {
+	case bc.size < bc.minSize:
+		bc.size = bc.minSize
+	case bc.size > bc.maxSize:
+		bc.size = bc.maxSize
+	}
+	switch {
+	case bc.interval < bc.minInterval:
+		bc.interval = bc.minInterval
+	case bc.interval > bc.maxInterval:
+		bc.interval = bc.maxInterval
+	}
+	return bc, nil
+}
@@ -0,0 +1,93 @@
+package esworker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateBatchController(t *testing.T) {
+	assert := assert.New(t)
+
+	bc, err := createBatchController(testCfg(V7))
+	assert.NoError(err)
+	assert.Nil(bc)
+
+	tests := map[string]struct {
+		minSize, maxSize         int
+		minInterval, maxInterval time.Duration
+		size                     int
+		interval                 time.Duration
+		isErr                    bool
+	}{
+		"zero size":         {minSize: 0, maxSize: 10, minInterval: time.Millisecond, maxInterval: time.Second, isErr: true},
+		"reversed size":     {minSize: 10, maxSize: 5, minInterval: time.Millisecond, maxInterval: time.Second, isErr: true},
+		"zero interval":     {minSize: 1, maxSize: 10, minInterval: 0, maxInterval: time.Second, isErr: true},
+		"reversed interval": {minSize: 1, maxSize: 10, minInterval: time.Second, maxInterval: time.Millisecond, isErr: true},
+		"clamped": {minSize: 1, maxSize: 100, minInterval: time.Millisecond, maxInterval: 100 * time.Millisecond,
+			size: 100, interval: 100 * time.Millisecond},
+		"in bounds": {minSize: 100, maxSize: 5000, minInterval: time.Millisecond, maxInterval: 10 * time.Second,
+			size: defaultWorkerQueueSize, interval: time.Second},
+	}
+
+	for name, t := range tests {
+		cfg := testCfg(V7)
+		WithAdaptiveBatchOption(t.minSize, t.maxSize, t.minInterval, t.maxInterval).apply(cfg)
+		bc, err := createBatchController(cfg)
+		assert.Equal(t.isErr, err != nil, name)
+		if err == nil {
+			assert.Equal(t.size, bc.batchSize(), name)
+			assert.Equal(t.interval, bc.flushInterval(), name)
+		}
+	}
+}
+
+func TestBatchController_Observe(t *testing.T) {
+	assert := assert.New(t)
+
+	newController := func() *batchController {
+		return &batchController{size: 100, minSize: 10, maxSize: 200, sizeStep: 20,
+			interval: time.Second, minInterval: 100 * time.Millisecond, maxInterval: 4 * time.Second,
+			intervalStep: 100 * time.Millisecond, targetLatency: time.Second, targetBytes: 1000}
+	}
+
+	tests := map[string]struct {
+		count    int
+		latency  time.Duration
+		bytes    int
+		rejected bool
+		size     int
+		interval time.Duration
+	}{
+		"full and fast":     {count: 100, latency: 10 * time.Millisecond, bytes: 100, size: 120, interval: 900 * time.Millisecond},
+		"not full and fast": {count: 50, latency: 10 * time.Millisecond, bytes: 100, size: 100, interval: 900 * time.Millisecond},
+		"rejected":          {count: 100, latency: 10 * time.Millisecond, rejected: true, size: 50, interval: 2 * time.Second},
+		"slow":              {count: 100, latency: 2 * time.Second, size: 50, interval: 2 * time.Second},
+		"large":             {count: 100, latency: 10 * time.Millisecond, bytes: 2000, size: 50, interval: 2 * time.Second},
+	}
+
+	for name, t := range tests {
+		bc := newController()
+		bc.observe(t.count, t.latency, t.bytes, t.rejected)
+		assert.Equal(t.size, bc.batchSize(), name)
+		assert.Equal(t.interval, bc.flushInterval(), name)
+	}
+
+	// they are kept within bounds.
+	bc := newController()
+	for i := 0; i < 20; i++ {
+		bc.observe(bc.batchSize(), time.Millisecond, 0, false)
+	}
+	assert.Equal(200, bc.batchSize())
+	assert.Equal(100*time.Millisecond, bc.flushInterval())
+	for i := 0; i < 20; i++ {
+		bc.observe(bc.batchSize(), time.Millisecond, 0, true)
+	}
+	assert.Equal(10, bc.batchSize())
+	assert.Equal(4*time.Second, bc.flushInterval())
+
+	// nil is not adjusted.
+	var disabled *batchController
+	disabled.observe(1, time.Millisecond, 0, true)
+}
@@ -0,0 +1,104 @@
+package esworker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+type (
+	// Backend sends a bulk request to the elasticsearch, and returns a parsed response.
+	// a backend passed to WithBackend is shared with every worker, so it should be safe for concurrent use.
+	Backend interface {
+		Bulk(ctx context.Context, req *BulkRequest) (*ESResponseBulk, error)
+	}
+
+	// BulkRequest is a bulk request made by a worker.
+	BulkRequest struct {
+		Body    []byte            // NDJSON body of actions (it is reused after Bulk returns, so it must not be retained).
+		Header  map[string]string // additional http headers.
+		Params  map[string]string // additional query parameters such as filter_path.
+		Actions int               // count of actions in a body, which is used to allocate items of a response.
+	}
+
+	// BackendConfig is connection settings from options, which is used to make a backend.
+	BackendConfig struct {
+		Addresses []string          // a list of elastic search nodes to use.
+		Username  string            // username for http basic authentication.
+		Password  string            // password for http basic authentication.
+		CloudID   string            // endpoint for elastic cloud.
+		APIKey    string            // base64-encoded token for authorization.
+		Transport http.RoundTripper // http transport object.
+		Logger    *Logger           // intermediate logger.
+		Codec     Codec             // codec parsing a bulk response.
+	}
+
+	// BackendFactory makes a backend from connection settings.
+	BackendFactory func(cfg *BackendConfig) (Backend, error)
+)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[ESVersion]BackendFactory{}
+)
+
+// RegisterBackend registers a factory of a backend for the version, and it replaces a registered one.
+// a dispatcher created with the version makes a backend per worker from the factory.
+func RegisterBackend(v ESVersion, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		delete(backends, v)
+		return
+	}
+	backends[v] = factory
+}
+
+// createBackend makes a backend registered for a version of the config.
+func createBackend(cfg *config) (Backend, error) {
+	if cfg.backend != nil {
+		return cfg.backend, nil
+	}
+
+	backendsMu.RLock()
+	factory, ok := backends[cfg.version]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("[err] createBackend (not registered version %s)", cfg.version.GetString())
+	}
+
+	return factory(&BackendConfig{
+		Addresses: cfg.addrs,
+		Username:  cfg.username,
+		Password:  cfg.password,
+		CloudID:   cfg.cloudId,
+		APIKey:    cfg.apiKey,
+		Transport: cfg.transport,
+		Logger:    cfg.logger,
+		Codec:     getCodec(cfg.codec),
+	})
+}
+
+// decodeBulkResponse parses a body of a bulk response with a codec, and closes it.
+// items of a response are allocated for the count of actions in advance.
+// it returns BulkRequestError with the body if status is less than 200 or more than 299.
+func decodeBulkResponse(body io.ReadCloser, status int, codec Codec, actions int) (*ESResponseBulk, error) {
+	if body == nil {
+		return nil, fmt.Errorf("[err] Bulk (empty body)")
+	}
+	defer body.Close()
+
+	if status < 200 || status > 299 {
+		msg, _ := ioutil.ReadAll(body)
+		return nil, &BulkRequestError{Status: status, Body: string(msg)}
+	}
+
+	result := &ESResponseBulk{Items: make([]ESResponseItem, 0, actions)}
+	if err := getCodec(codec).Decode(body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,214 @@
+package esworker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	es5 "github.com/elastic/go-elasticsearch/v5"
+	es6 "github.com/elastic/go-elasticsearch/v6"
+	es7 "github.com/elastic/go-elasticsearch/v7"
+	es8 "github.com/elastic/go-elasticsearch/v8"
+	opensearch "github.com/opensearch-project/opensearch-go"
+
+	es8_logger "github.com/elastic/elastic-transport-go/v8/elastictransport"
+	es5_logger "github.com/elastic/go-elasticsearch/v5/estransport"
+	es6_logger "github.com/elastic/go-elasticsearch/v6/estransport"
+	es7_logger "github.com/elastic/go-elasticsearch/v7/estransport"
+	os_logger "github.com/opensearch-project/opensearch-go/opensearchtransport"
+)
+
+const (
+	bulkPath        = "/_bulk"
+	bulkContentType = "application/x-ndjson"
+)
+
+func init() {
+	RegisterBackend(V5, newES5Backend)
+	RegisterBackend(V6, newES6Backend)
+	RegisterBackend(V7, newES7Backend)
+	RegisterBackend(V8, newES8Backend)
+	RegisterBackend(OpenSearch, newOpenSearchBackend)
+}
+
+// performer performs a http request to nodes, which a client of every version implements.
+type performer interface {
+	Perform(req *http.Request) (*http.Response, error)
+}
+
+// esBackend is a backend sending bulk requests with a client which is made at the first request.
+type esBackend struct {
+	sync.Mutex
+	newClient func() (performer, error)
+	client    performer
+	codec     Codec
+}
+
+// Bulk sends a bulk request.
+func (b *esBackend) Bulk(ctx context.Context, req *BulkRequest) (*ESResponseBulk, error) {
+	client, err := b.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, bulkPath, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Params) > 0 {
+		query := httpReq.URL.Query()
+		for key, value := range req.Params {
+			query.Set(key, value)
+		}
+		httpReq.URL.RawQuery = query.Encode()
+	}
+	httpReq.Header.Set("Content-Type", bulkContentType)
+	for key, value := range req.Header {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := client.Perform(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return decodeBulkResponse(resp.Body, resp.StatusCode, b.codec, req.Actions)
+}
+
+// getClient makes a client at the first request.
+func (b *esBackend) getClient() (performer, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.client == nil {
+		client, err := b.newClient()
+		if err != nil {
+			return nil, err
+		}
+		b.client = client
+	}
+	return b.client, nil
+}
+
+// newES5Backend is to make a backend of elasticsearch v5 (default _type is doc).
+func newES5Backend(cfg *BackendConfig) (Backend, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("[err] newES5Backend empty params")
+	}
+
+	conf := es5.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: cfg.Transport,
+	}
+	if cfg.Logger != nil {
+		logger, err := cfg.Logger.GetESLogger(V5)
+		if err != nil {
+			return nil, err
+		}
+		conf.Logger = logger.(es5_logger.Logger)
+	}
+
+	return &esBackend{newClient: func() (performer, error) { return es5.NewClient(conf) }, codec: cfg.Codec}, nil
+}
+
+// newES6Backend is to make a backend of elasticsearch v6 (default _type is _doc).
+func newES6Backend(cfg *BackendConfig) (Backend, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("[err] newES6Backend empty params")
+	}
+
+	conf := es6.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: cfg.Transport,
+		CloudID:   cfg.CloudID,
+		APIKey:    cfg.APIKey,
+	}
+	if cfg.Logger != nil {
+		logger, err := cfg.Logger.GetESLogger(V6)
+		if err != nil {
+			return nil, err
+		}
+		conf.Logger = logger.(es6_logger.Logger)
+	}
+
+	return &esBackend{newClient: func() (performer, error) { return es6.NewClient(conf) }, codec: cfg.Codec}, nil
+}
+
+// newES7Backend is to make a backend of elasticsearch v7 (default _type is _doc).
+func newES7Backend(cfg *BackendConfig) (Backend, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("[err] newES7Backend empty params")
+	}
+
+	conf := es7.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: cfg.Transport,
+		CloudID:   cfg.CloudID,
+		APIKey:    cfg.APIKey,
+	}
+	if cfg.Logger != nil {
+		logger, err := cfg.Logger.GetESLogger(V7)
+		if err != nil {
+			return nil, err
+		}
+		conf.Logger = logger.(es7_logger.Logger)
+	}
+
+	return &esBackend{newClient: func() (performer, error) { return es7.NewClient(conf) }, codec: cfg.Codec}, nil
+}
+
+// newES8Backend is to make a backend of elasticsearch v8 (no _type).
+func newES8Backend(cfg *BackendConfig) (Backend, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("[err] newES8Backend empty params")
+	}
+
+	conf := es8.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: cfg.Transport,
+		CloudID:   cfg.CloudID,
+		APIKey:    cfg.APIKey,
+		// requests are sent with compatible-with=8 headers, which keeps working on later versions.
+		EnableCompatibilityMode: true,
+	}
+	if cfg.Logger != nil {
+		logger, err := cfg.Logger.GetESLogger(V8)
+		if err != nil {
+			return nil, err
+		}
+		conf.Logger = logger.(es8_logger.Logger)
+	}
+
+	return &esBackend{newClient: func() (performer, error) { return es8.NewClient(conf) }, codec: cfg.Codec}, nil
+}
+
+// newOpenSearchBackend is to make a backend of opensearch (no _type).
+func newOpenSearchBackend(cfg *BackendConfig) (Backend, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("[err] newOpenSearchBackend empty params")
+	}
+
+	conf := opensearch.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: cfg.Transport,
+	}
+	if cfg.Logger != nil {
+		logger, err := cfg.Logger.GetESLogger(OpenSearch)
+		if err != nil {
+			return nil, err
+		}
+		conf.Logger = logger.(os_logger.Logger)
+	}
+
+	return &esBackend{newClient: func() (performer, error) { return opensearch.NewClient(conf) }, codec: cfg.Codec}, nil
+}
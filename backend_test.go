@@ -0,0 +1,234 @@
+package esworker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockBackend returns a successful response for every line of actions.
+type mockBackend struct {
+	sync.Mutex
+	bodies  [][]byte
+	headers []map[string]string
+	params  []map[string]string
+}
+
+func (mb *mockBackend) Bulk(ctx context.Context, req *BulkRequest) (*ESResponseBulk, error) {
+	mb.Lock()
+	mb.bodies = append(mb.bodies, append([]byte{}, req.Body...))
+	mb.headers = append(mb.headers, req.Header)
+	mb.params = append(mb.params, req.Params)
+	mb.Unlock()
+
+	body := req.Body
+	if req.Header["Content-Encoding"] == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body, err = ioutil.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &ESResponseBulk{}
+	for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+		meta := map[string]interface{}{}
+		if err := json.Unmarshal(line, &meta); err != nil {
+			return nil, err
+		}
+		for op, value := range meta {
+			fields, ok := value.(map[string]interface{})
+			if _, exist := fields["_index"]; !ok || !exist {
+				continue // a document line.
+			}
+			status := ESResponseStatus{Status: 200}
+			status.Id, _ = fields["_id"].(string)
+			item := ESResponseItem{}
+			switch op {
+			case "create":
+				item.Create = status
+			case "update":
+				item.Update = status
+			case "delete":
+				item.Delete = status
+			default:
+				item.Index = status
+			}
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	return resp, nil
+}
+
+func TestRegisterBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	custom := ESVersion(100)
+	_, err := createBackend(testCfg(custom))
+	assert.Error(err)
+
+	backend := &mockBackend{}
+	var received *BackendConfig
+	RegisterBackend(custom, func(cfg *BackendConfig) (Backend, error) {
+		received = cfg
+		return backend, nil
+	})
+	defer RegisterBackend(custom, nil)
+
+	cfg := testCfg(custom)
+	WithAddressesOption([]string{"http://localhost:9200"}).apply(cfg)
+	WithUsernameOption("allan").apply(cfg)
+	created, err := createBackend(cfg)
+	assert.NoError(err)
+	assert.Equal(backend, created)
+	assert.Equal([]string{"http://localhost:9200"}, received.Addresses)
+	assert.Equal("allan", received.Username)
+
+	RegisterBackend(custom, nil)
+	_, err = createBackend(testCfg(custom))
+	assert.Error(err)
+}
+
+func TestDecodeBulkResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := decodeBulkResponse(nil, 200, nil, 0)
+	assert.Error(err)
+
+	_, err = decodeBulkResponse(ioutil.NopCloser(strings.NewReader(`{"error": "bad request"}`)), 400, nil, 0)
+	reqErr := &BulkRequestError{}
+	assert.True(errors.As(err, &reqErr))
+	assert.Equal(400, reqErr.Status)
+	assert.Contains(err.Error(), "bad request")
+
+	_, err = decodeBulkResponse(ioutil.NopCloser(strings.NewReader(`{`)), 200, nil, 0)
+	assert.Error(err)
+
+	resp, err := decodeBulkResponse(ioutil.NopCloser(strings.NewReader(
+		`{"errors": true, "items": [{"index": {"_id": "1", "status": 201}}, {"delete": {"_id": "2", "status": 404}}]}`)), 200, defaultCodec, 2)
+	assert.NoError(err)
+	success, fail := resp.Count()
+	assert.Equal(1, success)
+	assert.Equal(1, fail)
+}
+
+func TestNewESBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	factories := map[ESVersion]BackendFactory{
+		V5:         newES5Backend,
+		V6:         newES6Backend,
+		V7:         newES7Backend,
+		V8:         newES8Backend,
+		OpenSearch: newOpenSearchBackend,
+	}
+
+	for v, factory := range factories {
+		_, err := factory(nil)
+		assert.Error(err)
+
+		backend, err := factory(&BackendConfig{
+			Transport: http.DefaultTransport,
+			Logger:    &Logger{Type: LOGGER_TYPE_TEXT, Output: ioutil.Discard},
+		})
+		assert.NoError(err, v.GetString())
+		assert.NotNil(backend)
+	}
+}
+
+func TestESBackend_Bulk(t *testing.T) {
+	assert := assert.New(t)
+
+	type request struct {
+		method, path, query, contentType, custom, body string
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/" { // a product check of a client.
+			w.Write([]byte(`{"version": {"number": "7.17.0", "build_flavor": "default"}, "tagline": "You Know, for Search"}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{method: r.Method, path: r.URL.Path, query: r.URL.Query().Get("filter_path"), contentType: r.Header.Get("Content-Type"),
+			custom: r.Header.Get("X-Test"), body: string(body)}
+		w.Write([]byte(`{"errors": false, "items": [{"index": {"_id": "1", "status": 201}}]}`))
+	}))
+	defer server.Close()
+
+	factories := map[ESVersion]BackendFactory{
+		V5:         newES5Backend,
+		V6:         newES6Backend,
+		V7:         newES7Backend,
+		V8:         newES8Backend,
+		OpenSearch: newOpenSearchBackend,
+	}
+
+	body := `{"index":{"_index":"allan","_id":"1"}}` + "\n" + `{"field":"value"}` + "\n"
+	for v, factory := range factories {
+		backend, err := factory(&BackendConfig{Addresses: []string{server.URL}})
+		assert.NoError(err, v.GetString())
+
+		resp, err := backend.Bulk(context.Background(), &BulkRequest{Body: []byte(body),
+			Header: map[string]string{"X-Test": "header"}, Params: map[string]string{"filter_path": defaultBulkFilterPath}})
+		assert.NoError(err, v.GetString())
+		success, fail := resp.Count()
+		assert.Equal(1, success, v.GetString())
+		assert.Equal(0, fail, v.GetString())
+
+		req := <-requests
+		assert.Equal(http.MethodPost, req.method, v.GetString())
+		assert.Equal("/_bulk", req.path, v.GetString())
+		assert.Equal(defaultBulkFilterPath, req.query, v.GetString())
+		if v == V8 { // a compatibility mode replaces a content type.
+			assert.Contains(req.contentType, "compatible-with=8", v.GetString())
+		} else {
+			assert.Contains(req.contentType, "x-ndjson", v.GetString())
+		}
+		assert.Equal("header", req.custom, v.GetString())
+		assert.Equal(body, req.body, v.GetString())
+	}
+}
+
+func TestBackend_Dispatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := &mockBackend{}
+	d, err := NewDispatcher(WithESVersionOption(V8), WithBackend(backend))
+	assert.NoError(err)
+	assert.NoError(d.Start())
+
+	result, err := d.AddActionWithResult(context.Background(), &mockAction{op: ES_INDEX, index: "allan", id: "1",
+		doc: map[string]interface{}{"field": "value"}})
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	success, _, err := d.Flush(ctx)
+	assert.NoError(err)
+	assert.Equal(1, success)
+
+	status, err := result.Wait(ctx)
+	assert.NoError(err)
+	assert.Equal("1", status.Id)
+	assert.NoError(d.Stop())
+
+	backend.Lock()
+	defer backend.Unlock()
+	assert.Len(backend.bodies, 1)
+	assert.Equal(`{"index":{"_index":"allan","_id":"1"}}`+"\n"+`{"field":"value"}`+"\n", string(backend.bodies[0]))
+}
@@ -0,0 +1,170 @@
+package esworker
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// documentKey returns a key of a document which an action targets (empty if its id is generated by Elasticsearch).
+func documentKey(act Action) string {
+	if act.GetID() == "" {
+		return ""
+	}
+	return act.GetIndex() + "\x00" + act.GetDocType() + "\x00" + act.GetID()
+}
+
+// coalesce folds two consecutive actions on the same document into an action which has the same effect.
+// a later index or delete supersedes an earlier action, and a partial doc of an update is merged into
+// an earlier index or update. it returns false if they could not be folded.
+func coalesce(prev, next Action) (Action, bool) {
+	prevMeta, ok := foldableMeta(prev)
+	if !ok {
+		return nil, false
+	}
+	nextMeta, ok := foldableMeta(next)
+	if !ok || prevMeta != nextMeta {
+		return nil, false
+	}
+
+	switch next.GetOperation() {
+	case ES_INDEX, ES_DELETE:
+		return next, true
+	case ES_UPDATE:
+	default:
+		return nil, false
+	}
+
+	nextDoc, nextUpsert, ok := partialDoc(next)
+	if !ok {
+		return nil, false
+	}
+	switch prev.GetOperation() {
+	case ES_INDEX:
+		// a pipeline of an index would process a merged document unlike the update.
+		if prevMeta.Pipeline != "" {
+			return nil, false
+		}
+		prevDoc, ok := fullDoc(prev)
+		if !ok {
+			return nil, false
+		}
+		return &StandardAction{Op: ES_INDEX, Index: prev.GetIndex(), DocType: prev.GetDocType(), Id: prev.GetID(),
+			Doc: mergeDoc(prevDoc, nextDoc), Meta: getActionMeta(prev)}, true
+	case ES_UPDATE:
+		prevDoc, prevUpsert, ok := partialDoc(prev)
+		// a document upserted by a later update would lack fields of an earlier update.
+		if !ok || (nextUpsert && !prevUpsert) {
+			return nil, false
+		}
+		return &UpdateAction{Index: prev.GetIndex(), DocType: prev.GetDocType(), Id: prev.GetID(),
+			Doc: mergeDoc(prevDoc, nextDoc), DocAsUpsert: prevUpsert, Meta: getActionMeta(next)}, true
+	default:
+		return nil, false
+	}
+}
+
+// writesDoc returns whether an action writes a document even if it does not exist.
+func writesDoc(act Action) bool {
+	switch act.GetOperation() {
+	case ES_INDEX, ES_CREATE:
+		return true
+	case ES_UPDATE:
+		_, upsert, ok := partialDoc(act)
+		return ok && upsert
+	default:
+		return false
+	}
+}
+
+// foldableMeta returns metadata of an action to be compared, and it returns false if the action has a concurrency control.
+func foldableMeta(act Action) (ActionMeta, bool) {
+	meta := getActionMeta(act)
+	if meta == nil {
+		return ActionMeta{}, true
+	}
+	if meta.Version != 0 || meta.IfSeqNo != nil || meta.IfPrimaryTerm != nil {
+		return ActionMeta{}, false
+	}
+	m := *meta
+	m.RetryOnConflict = 0
+	return m, true
+}
+
+// fullDoc returns a document of an index action as a map.
+func fullDoc(act Action) (map[string]interface{}, bool) {
+	switch a := act.(type) {
+	case RawDocAction:
+		doc := map[string]interface{}{}
+		if err := decodeNumber(a.GetRawDoc(), &doc); err != nil {
+			return nil, false
+		}
+		return doc, true
+	case ValueDocAction:
+		return nil, false
+	}
+	doc := act.GetDoc()
+	return doc, doc != nil
+}
+
+// partialDoc returns a partial doc of an update action and whether it is upserted.
+// it returns false if the update has other keys such as a script or an upsert.
+func partialDoc(act Action) (doc map[string]interface{}, docAsUpsert bool, ok bool) {
+	var body map[string]interface{}
+	switch a := act.(type) {
+	case *UpdateAction:
+		if a.Script != nil || a.Upsert != nil || a.ScriptedUpsert || a.Doc == nil {
+			return nil, false, false
+		}
+		return a.Doc, a.DocAsUpsert, true
+	case RawDocAction:
+		if err := decodeNumber(a.GetRawDoc(), &body); err != nil {
+			return nil, false, false
+		}
+	case ValueDocAction:
+		return nil, false, false
+	default:
+		body = act.GetDoc()
+	}
+
+	for key := range body {
+		if key != "doc" && key != "doc_as_upsert" {
+			return nil, false, false
+		}
+	}
+	doc, ok = body["doc"].(map[string]interface{})
+	if !ok {
+		return nil, false, false
+	}
+	if upsert, exist := body["doc_as_upsert"]; exist {
+		if docAsUpsert, ok = upsert.(bool); !ok {
+			return nil, false, false
+		}
+	}
+	return doc, docAsUpsert, true
+}
+
+// mergeDoc returns a document which src is merged into dst recursively as Elasticsearch merges a partial doc.
+// objects are merged, and other values including arrays are replaced. neither of them is modified.
+func mergeDoc(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for key, value := range dst {
+		merged[key] = value
+	}
+	for key, value := range src {
+		if srcObj, ok := value.(map[string]interface{}); ok {
+			if dstObj, ok := merged[key].(map[string]interface{}); ok {
+				merged[key] = mergeDoc(dstObj, srcObj)
+				continue
+			}
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// decodeNumber decodes JSON with numbers kept as json.Number not to lose precision.
+func decodeNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -0,0 +1,64 @@
+package esworker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesce(t *testing.T) {
+	assert := assert.New(t)
+
+	seqNo := int64(1)
+	index := &StandardAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: map[string]interface{}{"a": 1, "obj": map[string]interface{}{"b": 1, "c": 1}}}
+	update := &UpdateAction{Index: "allan", Id: "1", Doc: map[string]interface{}{"obj": map[string]interface{}{"c": 2}, "d": []interface{}{1}}}
+	merged := map[string]interface{}{"a": 1, "obj": map[string]interface{}{"b": 1, "c": 2}, "d": []interface{}{1}}
+	del := &StandardAction{Op: ES_DELETE, Index: "allan", Id: "1"}
+
+	tests := map[string]struct {
+		prev   Action
+		next   Action
+		output Action
+		folded bool
+	}{
+		"index supersedes":  {prev: update, next: index, output: index, folded: true},
+		"delete supersedes": {prev: index, next: del, output: del, folded: true},
+		"update into index": {prev: index, next: update, folded: true,
+			output: &StandardAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: merged}},
+		"update into update": {prev: &UpdateAction{Index: "allan", Id: "1", Doc: index.Doc, DocAsUpsert: true}, next: update, folded: true,
+			output: &UpdateAction{Index: "allan", Id: "1", Doc: merged, DocAsUpsert: true}},
+		"raw update into raw index": {folded: true,
+			prev:   &RawAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: []byte(`{"a": 12345678901234567890}`)},
+			next:   &RawAction{Op: ES_UPDATE, Index: "allan", Id: "1", Doc: []byte(`{"doc": {"b": 1}}`)},
+			output: &StandardAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: map[string]interface{}{"a": json.Number("12345678901234567890"), "b": json.Number("1")}}},
+		"upsert after update": {prev: update, next: &UpdateAction{Index: "allan", Id: "1", Doc: index.Doc, DocAsUpsert: true}},
+		"update into create":  {prev: &StandardAction{Op: ES_CREATE, Index: "allan", Id: "1", Doc: index.Doc}, next: update},
+		"update into delete":  {prev: del, next: update},
+		"create":              {prev: del, next: &StandardAction{Op: ES_CREATE, Index: "allan", Id: "1", Doc: index.Doc}},
+		"script":              {prev: index, next: &UpdateAction{Index: "allan", Id: "1", Script: &Script{Source: "ctx._source.a++"}}},
+		"value":               {prev: &mockValueAction{mockAction: mockAction{op: ES_INDEX, index: "allan", id: "1"}}, next: update},
+		"pipeline":            {prev: &StandardAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: index.Doc, Meta: &ActionMeta{Pipeline: "p"}}, next: update},
+		"different routing":   {prev: index, next: &StandardAction{Op: ES_DELETE, Index: "allan", Id: "1", Meta: &ActionMeta{Routing: "r"}}},
+		"version":             {prev: index, next: &StandardAction{Op: ES_DELETE, Index: "allan", Id: "1", Meta: &ActionMeta{Version: 2, VersionType: "external"}}},
+		"seq no":              {prev: &StandardAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: index.Doc, Meta: &ActionMeta{IfSeqNo: &seqNo, IfPrimaryTerm: &seqNo}}, next: del},
+		"retry on conflict": {prev: update, next: &UpdateAction{Index: "allan", Id: "1", Doc: update.Doc, RetryOnConflict: 3}, folded: true,
+			output: &UpdateAction{Index: "allan", Id: "1", Doc: update.Doc, Meta: &ActionMeta{RetryOnConflict: 3}}},
+	}
+
+	for name, t := range tests {
+		output, folded := coalesce(t.prev, t.next)
+		assert.Equal(t.folded, folded, name)
+		assert.Equal(t.output, output, name)
+	}
+
+	// documents of actions are not modified.
+	assert.Equal(map[string]interface{}{"b": 1, "c": 1}, index.Doc["obj"])
+}
+
+func TestDocumentKey(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("allan\x00_doc\x001", documentKey(&mockAction{index: "allan", docType: "_doc", id: "1"}))
+	assert.Equal("", documentKey(&mockAction{index: "allan"}))
+}
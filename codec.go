@@ -0,0 +1,171 @@
+package esworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Codec serializes lines of a bulk body and parses a bulk response.
+// it is shared with every worker, so it should be safe for concurrent use.
+type Codec interface {
+	// Encode writes v to w as JSON in a line (a trailing newline is optional).
+	Encode(w io.Writer, v interface{}) error
+	// Decode parses JSON read from r into v.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// StdCodec is a codec with encoding/json, which is used by default.
+type StdCodec struct{}
+
+// Encode writes v to w with json.Encoder.
+func (StdCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Decode parses JSON read from r into v with json.Decoder, and a bulk response is decoded item by item.
+func (StdCodec) Decode(r io.Reader, v interface{}) error {
+	if bulk, ok := v.(*ESResponseBulk); ok {
+		return decodeBulkStream(r, bulk)
+	}
+	return json.NewDecoder(r).Decode(v)
+}
+
+var defaultCodec Codec = StdCodec{}
+
+type (
+	// bulkSuccessStatus is a status of an item without an error.
+	bulkSuccessStatus struct {
+		Index       string `json:"_index"`
+		DocType     string `json:"_type"`
+		Id          string `json:"_id"`
+		Version     int64  `json:"_version"`
+		Result      string `json:"result"`
+		SeqNo       int64  `json:"_seq_no"`
+		PrimaryTerm int64  `json:"_primary_term"`
+		Status      int    `json:"status"`
+	}
+
+	// bulkSuccessItem is an item of a bulk response which has no failed item.
+	bulkSuccessItem struct {
+		Index  *bulkSuccessStatus `json:"index"`
+		Create *bulkSuccessStatus `json:"create"`
+		Update *bulkSuccessStatus `json:"update"`
+		Delete *bulkSuccessStatus `json:"delete"`
+	}
+)
+
+// status converts to a status of a response item.
+func (s *bulkSuccessStatus) status() ESResponseStatus {
+	if s == nil {
+		return ESResponseStatus{}
+	}
+	return ESResponseStatus{Index: s.Index, DocType: s.DocType, Id: s.Id, Version: s.Version,
+		Result: s.Result, SeqNo: s.SeqNo, PrimaryTerm: s.PrimaryTerm, Status: s.Status}
+}
+
+// decodeBulkStream decodes a bulk response item by item into a bulk whose items could be allocated in advance.
+// if errors is false before items, items are decoded without error fields because none of them failed.
+func decodeBulkStream(r io.Reader, bulk *ESResponseBulk) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	succeeded := false
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "errors":
+			if err := dec.Decode(&bulk.Errors); err != nil {
+				return err
+			}
+			succeeded = !bulk.Errors
+		case "items":
+			if err := decodeBulkItems(dec, bulk, succeeded); err != nil {
+				return err
+			}
+		default:
+			if err := dec.Decode(&json.RawMessage{}); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// decodeBulkItems decodes an array of items one by one.
+func decodeBulkItems(dec *json.Decoder, bulk *ESResponseBulk, succeeded bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		bulk.Items = nil
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("[err] decodeBulkItems (unexpected token %v)", tok)
+	}
+
+	bulk.Items = bulk.Items[:0]
+	for dec.More() {
+		if !succeeded {
+			item := ESResponseItem{}
+			if err := dec.Decode(&item); err != nil {
+				return err
+			}
+			bulk.Items = append(bulk.Items, item)
+			continue
+		}
+
+		success := bulkSuccessItem{}
+		if err := dec.Decode(&success); err != nil {
+			return err
+		}
+		bulk.Items = append(bulk.Items, ESResponseItem{
+			Index:  success.Index.status(),
+			Create: success.Create.status(),
+			Update: success.Update.status(),
+			Delete: success.Delete.status(),
+		})
+	}
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads a delimiter from a decoder.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("[err] expectDelim (expected %v but %v)", delim, tok)
+	}
+	return nil
+}
+
+// getCodec returns a default codec if a codec is not given.
+func getCodec(codec Codec) Codec {
+	if codec == nil {
+		return defaultCodec
+	}
+	return codec
+}
+
+// encodeLine writes v to a buffer as a line of a bulk body.
+func encodeLine(buf *bytes.Buffer, codec Codec, v interface{}) error {
+	start := buf.Len()
+	if err := codec.Encode(buf, v); err != nil {
+		buf.Truncate(start)
+		return err
+	}
+	if buf.Len() == start || buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build gojson
+// +build gojson
+
+package esworker
+
+import (
+	"io"
+
+	gojson "github.com/goccy/go-json"
+)
+
+// goJSONCodec is a drop-in codec with goccy/go-json, which is benchmarked with a gojson build tag.
+type goJSONCodec struct{}
+
+func (goJSONCodec) Encode(w io.Writer, v interface{}) error {
+	return gojson.NewEncoder(w).Encode(v)
+}
+
+func (goJSONCodec) Decode(r io.Reader, v interface{}) error {
+	return gojson.NewDecoder(r).Decode(v)
+}
+
+func init() {
+	benchCodecs["gojson"] = goJSONCodec{}
+}
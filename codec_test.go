@@ -0,0 +1,369 @@
+package esworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockCodec writes JSON without a trailing newline, and counts calls.
+type mockCodec struct {
+	encodes int32
+	decodes int32
+}
+
+func (mc *mockCodec) Encode(w io.Writer, v interface{}) error {
+	atomic.AddInt32(&mc.encodes, 1)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (mc *mockCodec) Decode(r io.Reader, v interface{}) error {
+	atomic.AddInt32(&mc.decodes, 1)
+	return json.NewDecoder(r).Decode(v)
+}
+
+func TestStdCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(StdCodec{}.Encode(buf, map[string]interface{}{"field": 1}))
+	assert.Equal(`{"field":1}`+"\n", buf.String())
+
+	doc := map[string]interface{}{}
+	assert.NoError(StdCodec{}.Decode(buf, &doc))
+	assert.Equal(float64(1), doc["field"])
+}
+
+func TestEncodeLine(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		codec  Codec
+		input  interface{}
+		output string
+		isErr  bool
+	}{
+		"std":        {codec: StdCodec{}, input: map[string]int{"a": 1}, output: `{"a":1}` + "\n"},
+		"no newline": {codec: &mockCodec{}, input: map[string]int{"a": 1}, output: `{"a":1}` + "\n"},
+		"error":      {codec: &mockCodec{}, input: make(chan int), isErr: true},
+	}
+
+	for name, t := range tests {
+		buf := bytes.NewBufferString("prev\n")
+		err := encodeLine(buf, t.codec, t.input)
+		assert.Equal(t.isErr, err != nil, name)
+		if err == nil {
+			assert.Equal("prev\n"+t.output, buf.String(), name)
+		} else {
+			assert.Equal("prev\n", buf.String(), name)
+		}
+	}
+}
+
+func TestWithCodecOption(t *testing.T) {
+	assert := assert.New(t)
+
+	codec := &mockCodec{}
+	cfg := testCfg(V7)
+	WithCodecOption(codec).apply(cfg)
+
+	proxy, err := createESProxy(cfg)
+	assert.NoError(err)
+	buf := &bytes.Buffer{}
+	assert.NoError(proxy.(*esproxy).makeReader(buf, []Action{
+		&mockAction{op: ES_INDEX, index: "allan", id: "1", doc: map[string]interface{}{"field": 1}},
+		&mockValueAction{mockAction: mockAction{op: ES_INDEX, index: "allan", id: "2"}, value: &mockDocument{Field1: 2}},
+	}))
+	assert.Equal(int32(4), atomic.LoadInt32(&codec.encodes))
+	assert.Equal(`{"index":{"_index":"allan","_type":"_doc","_id":"1"}}`+"\n"+`{"field":1}`+"\n"+
+		`{"index":{"_index":"allan","_type":"_doc","_id":"2"}}`+"\n"+`{"field1":2,"field2":""}`+"\n", buf.String())
+
+	_, err = decodeBulkResponse(ioutil.NopCloser(strings.NewReader(`{"errors": false, "items": []}`)), 200, codec, 0)
+	assert.NoError(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&codec.decodes))
+}
+
+func TestDecodeBulkStream(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input   string
+		success int
+		fail    int
+		isErr   bool
+	}{
+		"succeeded": {input: `{"took": 3, "errors": false, "items": [{"index": {"_index": "allan", "_id": "1", "_version": 1,
+			"result": "created", "_seq_no": 3, "_primary_term": 1, "status": 201}}, {"delete": {"_id": "2", "status": 200}}]}`, success: 2},
+		"failed": {input: `{"errors": true, "items": [{"index": {"_id": "1", "status": 201}}, {"update": {"_id": "2", "status": 429,
+			"error": {"type": "es_rejected_execution_exception", "reason": "rejected", "caused_by": {"type": "a", "reason": "b"}}}}]}`,
+			success: 1, fail: 1},
+		"items before errors": {input: `{"items": [{"create": {"_id": "1", "status": 409, "error": {"type": "conflict"}}}], "errors": true}`,
+			fail: 1},
+		"filtered":    {input: `{"errors": false, "items": [{"index": {"_id": "1", "status": 201}}]}`, success: 1},
+		"null items":  {input: `{"errors": false, "items": null}`},
+		"no items":    {input: `{"errors": false}`},
+		"not object":  {input: `[]`, isErr: true},
+		"not array":   {input: `{"items": {}}`, isErr: true},
+		"broken item": {input: `{"errors": true, "items": [{"index": 1}]}`, isErr: true},
+		"truncated":   {input: `{"errors": false, "items": [{"index": {"_id": "1"`, isErr: true},
+	}
+
+	for name, t := range tests {
+		bulk := &ESResponseBulk{Items: make([]ESResponseItem, 0, 2)}
+		err := StdCodec{}.Decode(strings.NewReader(t.input), bulk)
+		assert.Equal(t.isErr, err != nil, name)
+		if err != nil {
+			continue
+		}
+
+		// a result is same as decoding a whole response.
+		expected := &ESResponseBulk{}
+		assert.NoError(json.Unmarshal([]byte(t.input), expected), name)
+		assert.Equal(expected.Errors, bulk.Errors, name)
+		assert.Equal(len(expected.Items), len(bulk.Items), name)
+		for i := range expected.Items {
+			assert.Equal(expected.Items[i], bulk.Items[i], name)
+		}
+		success, fail := bulk.Count()
+		assert.Equal(t.success, success, name)
+		assert.Equal(t.fail, fail, name)
+		assert.Equal(expected.ResultError(), bulk.ResultError(), name)
+	}
+}
+
+// benchCodecs are codecs compared by benchmarks, and a codec to evaluate could be added here.
+// codecs with other libraries are added by build tags(e.g. gojson).
+var benchCodecs = map[string]Codec{
+	"std":     StdCodec{},
+	"marshal": marshalCodec{},
+}
+
+// marshalCodec is a codec with json.Marshal and json.Unmarshal, which decodes a bulk response as a whole.
+type marshalCodec struct{}
+
+func (marshalCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (marshalCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// benchEvent is a realistic document such as an access log.
+type benchEvent struct {
+	Timestamp time.Time         `json:"@timestamp"`
+	Message   string            `json:"message"`
+	Host      benchHost         `json:"host"`
+	HTTP      benchHTTP         `json:"http"`
+	Tags      []string          `json:"tags"`
+	Labels    map[string]string `json:"labels"`
+	Duration  int64             `json:"event.duration"`
+}
+
+type benchHost struct {
+	Name string   `json:"name"`
+	IP   []string `json:"ip"`
+	OS   string   `json:"os"`
+}
+
+type benchHTTP struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Bytes     int    `json:"bytes"`
+	UserAgent string `json:"user_agent"`
+	Referrer  string `json:"referrer"`
+}
+
+func newBenchEvent(i int) *benchEvent {
+	return &benchEvent{
+		Timestamp: time.Date(2021, 3, 1, 12, 0, i%60, 0, time.UTC),
+		Message:   fmt.Sprintf(`10.0.%d.%d - - "GET /api/v1/users/%d HTTP/1.1" 200 5120 "-" "Mozilla/5.0"`, i%255, i%200, i),
+		Host:      benchHost{Name: fmt.Sprintf("web-%03d", i%20), IP: []string{"10.0.0.1", "fe80::1"}, OS: "linux"},
+		HTTP: benchHTTP{Method: "GET", Path: fmt.Sprintf("/api/v1/users/%d", i), Status: 200, Bytes: 5120,
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0 Safari/537.36",
+			Referrer:  "https://example.com/dashboard?tab=users&page=2"},
+		Tags:     []string{"production", "api", "access-log"},
+		Labels:   map[string]string{"region": "ap-northeast-2", "zone": "a", "service": "user-api"},
+		Duration: int64(1500000 + i),
+	}
+}
+
+// benchEventMap converts an event to a map as it is given by StandardAction.
+func benchEventMap(i int) map[string]interface{} {
+	b, _ := json.Marshal(newBenchEvent(i))
+	doc := map[string]interface{}{}
+	json.Unmarshal(b, &doc)
+	return doc
+}
+
+// benchBulkResponse returns a response of 1000 items, and one of 100 items is rejected if failed is true.
+func benchBulkResponse(failed bool) []byte {
+	resp := &ESResponseBulk{Errors: failed}
+	for i := 0; i < 1000; i++ {
+		status := ESResponseStatus{Index: "logs-2021.03.01", DocType: "_doc", Id: fmt.Sprintf("%d", i),
+			Version: 1, Result: "created", SeqNo: int64(i), PrimaryTerm: 1, Status: 201}
+		if failed && i%100 == 0 {
+			status.Status = 429
+			status.Result = ""
+			status.Error = ESResponseError{Type: "es_rejected_execution_exception", Reason: "rejected execution of coordinating operation"}
+		}
+		resp.Items = append(resp.Items, ESResponseItem{Index: status})
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
+
+func TestBenchCodecs(t *testing.T) {
+	assert := assert.New(t)
+
+	acts := make([]Action, 10)
+	for i := range acts {
+		acts[i] = &mockValueAction{mockAction: mockAction{op: ES_INDEX, index: "logs-2021.03.01", id: fmt.Sprintf("%d", i)},
+			value: newBenchEvent(i)}
+	}
+	body := benchBulkResponse(true)
+
+	// every codec compared by benchmarks makes the same bulk body and response as a default codec.
+	var expectedBody []byte
+	var expectedResp *ESResponseBulk
+	for _, name := range []string{"std", "marshal", "gojson"} {
+		codec, ok := benchCodecs[name]
+		if !ok {
+			continue
+		}
+		cfg := testCfg(V7)
+		WithCodecOption(codec).apply(cfg)
+		proxy, err := createESProxy(cfg)
+		assert.NoError(err, name)
+		buf := &bytes.Buffer{}
+		assert.NoError(proxy.(*esproxy).makeReader(buf, acts), name)
+
+		resp := &ESResponseBulk{}
+		assert.NoError(codec.Decode(bytes.NewReader(body), resp), name)
+		if expectedBody == nil {
+			expectedBody, expectedResp = buf.Bytes(), resp
+			continue
+		}
+		assert.Equal(string(expectedBody), buf.String(), name)
+		assert.Equal(expectedResp.Items, resp.Items, name)
+	}
+}
+
+func BenchmarkCodec_EncodeStruct(b *testing.B) {
+	events := make([]*benchEvent, 1000)
+	for i := range events {
+		events[i] = newBenchEvent(i)
+	}
+
+	for name, codec := range benchCodecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			buf := &bytes.Buffer{}
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				for _, event := range events {
+					if err := codec.Encode(buf, event); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
+
+func BenchmarkCodec_EncodeMap(b *testing.B) {
+	docs := make([]map[string]interface{}, 1000)
+	for i := range docs {
+		docs[i] = benchEventMap(i)
+	}
+
+	for name, codec := range benchCodecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			buf := &bytes.Buffer{}
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				for _, doc := range docs {
+					if err := codec.Encode(buf, doc); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
+
+func BenchmarkCodec_DecodeResponse(b *testing.B) {
+	bodies := map[string][]byte{"succeeded": benchBulkResponse(false), "failed": benchBulkResponse(true)}
+
+	for name, codec := range benchCodecs {
+		for result, body := range bodies {
+			body := body
+			b.Run(name+"/"+result, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(body)))
+				for i := 0; i < b.N; i++ {
+					resp := &ESResponseBulk{Items: make([]ESResponseItem, 0, 1000)}
+					if err := codec.Decode(bytes.NewReader(body), resp); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCodec_MakeReader(b *testing.B) {
+	acts := make([]Action, 1000)
+	for i := range acts {
+		acts[i] = &mockValueAction{mockAction: mockAction{op: ES_INDEX, index: "logs-2021.03.01", id: fmt.Sprintf("%d", i)},
+			value: newBenchEvent(i)}
+	}
+
+	for name, codec := range benchCodecs {
+		cfg := testCfg(V7)
+		WithCodecOption(codec).apply(cfg)
+		proxy, err := createESProxy(cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ep := proxy.(*esproxy)
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			buf := &bytes.Buffer{}
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := ep.makeReader(buf, acts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package esworker
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
@@ -9,11 +10,20 @@ const (
 	V5 ESVersion = iota
 	V6
 	V7
+	V8
+	OpenSearch
 )
 
 // ErrorHandler is called when an error is raised.
 type ErrorHandler func(error)
 
+// BeforeBulkHook is called before a worker sends a bulk request.
+type BeforeBulkHook func(ctx context.Context, workerID int, acts []Action)
+
+// AfterBulkHook is called after a worker receives a response of a bulk request.
+// resp is nil if err is returned from the request, and items in resp could be failed even if err is nil.
+type AfterBulkHook func(ctx context.Context, workerID int, acts []Action, resp *ESResponseBulk, err error)
+
 // config is environment variable, which is used to dispatcher.
 type config struct {
 	version            ESVersion         // elastic search version
@@ -24,11 +34,47 @@ type config struct {
 	apiKey             string            // base64-encoded token for authorization.
 	transport          http.RoundTripper // http transport object.
 	logger             *Logger           // intermediate logger.
+	backend            Backend           // backend sending bulk requests instead of a registered one.
+	codec              Codec             // it serializes bulk bodies and parses bulk responses.
+	bulkFilterPath     string            // filter_path of bulk responses (every field if empty).
+	gzip               bool              // whether bulk bodies are compressed by gzip.
+	gzipLevel          int               // compression level of gzip.
+	gzipMinSize        int               // a bulk body smaller than it is not compressed.
 	globalQueueSize    int               // as queue size, it is the maximum value that could store an action.
 	workerSize         int               // worker size to currently run to process an action.
 	workerQueueSize    int               // worker queue size to limit action pushing.
 	workerWaitInterval time.Duration     // it is a wait time that would forcedly send a request to the elasticsearch when no event.
+	workerMaxBulkBytes int               // maximum bytes of a bulk body at a worker (unlimited if 0).
+	partition          PartitionFunc     // it routes an action to a worker by its key (disabled if nil).
+	coalesce           bool              // whether an action is folded into a queued action on the same document.
 	errorHandler       ErrorHandler      // it is calling when an error raises.
+	beforeBulkHook     BeforeBulkHook    // it is calling before a bulk request.
+	afterBulkHook      AfterBulkHook     // it is calling after a bulk request.
+
+	adaptive              bool          // whether a worker adjusts its batch size and wait interval.
+	adaptiveMinSize       int           // lower bound of a batch size in an adaptive mode.
+	adaptiveMaxSize       int           // upper bound of a batch size in an adaptive mode.
+	adaptiveMinInterval   time.Duration // lower bound of a wait interval in an adaptive mode.
+	adaptiveMaxInterval   time.Duration // upper bound of a wait interval in an adaptive mode.
+	adaptiveTargetLatency time.Duration // a bulk slower than it is regarded as congestion.
+	adaptiveTargetBytes   int           // a bulk larger than it is regarded as congestion (not regarded if 0).
+
+	retryMaxAttempts     int           // maximum count to send an item including the first try.
+	retryInitialInterval time.Duration // wait time before the first retry.
+	retryMaxInterval     time.Duration // upper bound of wait time between retries.
+	retryMultiplier      float64       // factor to grow wait time between retries.
+	retryJitter          float64       // randomization factor(0 ~ 1) applied to wait time.
+	retryPolicy          RetryPolicy   // it decides which failed item is retried.
+
+	deadLetterSink DeadLetterSink // it receives actions which permanently failed.
+
+	overflowPolicy   OverflowPolicy // what AddAction does when a global queue is full.
+	overflowSpillDir string         // directory of spill files with OVERFLOW_SPILL.
+
+	walDir          string        // directory of a write-ahead log (disabled if empty).
+	walSegmentSize  int64         // maximum bytes of a segment file in a write-ahead log.
+	walSyncPolicy   WALSyncPolicy // when a write-ahead log is flushed to a disk.
+	walSyncInterval time.Duration // interval to flush a write-ahead log with WAL_SYNC_INTERVAL.
 }
 
 // Option is something for dependency injection.
@@ -52,11 +98,20 @@ func (v ESVersion) GetString() string {
 		return "ES6.X"
 	case V7:
 		return "ES7.X"
+	case V8:
+		return "ES8.X"
+	case OpenSearch:
+		return "OpenSearch"
 	default:
 		return "unknown"
 	}
 }
 
+// typeless returns whether documents have no _type at the version.
+func (v ESVersion) typeless() bool {
+	return v == V8 || v == OpenSearch
+}
+
 // WithESVersionOption has associated version that elastic search nodes are running
 func WithESVersionOption(v ESVersion) OptionFunc {
 	return func(cfg *config) {
@@ -113,6 +168,39 @@ func WithLoggerOption(logger *Logger) OptionFunc {
 	}
 }
 
+// WithBackend has associated a backend which sends bulk requests instead of a backend registered for the version.
+// the version is still used to make a bulk body.
+func WithBackend(backend Backend) OptionFunc {
+	return func(cfg *config) {
+		cfg.backend = backend
+	}
+}
+
+// WithCodecOption has associated a codec which serializes bulk bodies and parses bulk responses instead of encoding/json.
+func WithCodecOption(codec Codec) OptionFunc {
+	return func(cfg *config) {
+		cfg.codec = codec
+	}
+}
+
+// WithBulkFilterPathOption has associated filter_path of bulk responses, and every field of items is returned if it is empty.
+// fields of an item which are used by a dispatcher(_id, status and error) should be included.
+func WithBulkFilterPathOption(path string) OptionFunc {
+	return func(cfg *config) {
+		cfg.bulkFilterPath = path
+	}
+}
+
+// WithGzipOption has associated gzip compression of bulk bodies with the level(gzip.BestSpeed ~ gzip.BestCompression, gzip.DefaultCompression).
+// a bulk body smaller than minSize bytes is sent without compression.
+func WithGzipOption(level, minSize int) OptionFunc {
+	return func(cfg *config) {
+		cfg.gzip = true
+		cfg.gzipLevel = level
+		cfg.gzipMinSize = minSize
+	}
+}
+
 // WithGlobalQueueSizeOption has associated queue size in global.
 func WithGlobalQueueSizeOption(size int) OptionFunc {
 	return func(cfg *config) {
@@ -141,9 +229,144 @@ func WithWorkerWaitInterval(d time.Duration) OptionFunc {
 	}
 }
 
+// WithWorkerMaxBulkBytesOption has associated maximum bytes of a bulk body at a worker.
+// a worker sends a request when queued actions exceed it, and an oversized request is split.
+func WithWorkerMaxBulkBytesOption(size int) OptionFunc {
+	return func(cfg *config) {
+		cfg.workerMaxBulkBytes = size
+	}
+}
+
+// WithPartitionOption enables a partitioning mode that actions with the same key are processed by the same worker in order.
+// esworker.PartitionByDocument keys actions by their index and id, and a custom key could be given.
+func WithPartitionOption(fn PartitionFunc) OptionFunc {
+	return func(cfg *config) {
+		cfg.partition = fn
+	}
+}
+
+// WithCoalesceOption enables coalescing that an action is folded into a queued action on the same document at a worker.
+// a later index or delete supersedes an earlier action, and a partial doc of an update is merged into an earlier index or update.
+func WithCoalesceOption(enabled bool) OptionFunc {
+	return func(cfg *config) {
+		cfg.coalesce = enabled
+	}
+}
+
+// WithAdaptiveBatchOption enables an adaptive mode that each worker adjusts its batch size and wait interval within bounds.
+// they start from a worker queue size and a wait interval, and they are adjusted by latency, 429 rejections and bytes of bulks.
+func WithAdaptiveBatchOption(minSize, maxSize int, minInterval, maxInterval time.Duration) OptionFunc {
+	return func(cfg *config) {
+		cfg.adaptive = true
+		cfg.adaptiveMinSize = minSize
+		cfg.adaptiveMaxSize = maxSize
+		cfg.adaptiveMinInterval = minInterval
+		cfg.adaptiveMaxInterval = maxInterval
+	}
+}
+
+// WithAdaptiveTargetOption has associated targets of a bulk in an adaptive mode.
+// a bulk slower than latency or larger than bytes shrinks a batch size (bytes are not regarded if 0).
+func WithAdaptiveTargetOption(latency time.Duration, bytes int) OptionFunc {
+	return func(cfg *config) {
+		cfg.adaptiveTargetLatency = latency
+		cfg.adaptiveTargetBytes = bytes
+	}
+}
+
+// WithOverflowPolicyOption has associated a policy deciding what AddAction does when a global queue is full.
+func WithOverflowPolicyOption(policy OverflowPolicy) OptionFunc {
+	return func(cfg *config) {
+		cfg.overflowPolicy = policy
+	}
+}
+
+// WithOverflowSpillDirOption has associated a directory of spill files, which is required with OVERFLOW_SPILL.
+func WithOverflowSpillDirOption(dir string) OptionFunc {
+	return func(cfg *config) {
+		cfg.overflowSpillDir = dir
+	}
+}
+
 // WithErrorHandler has associated a handler called when an error is raised.
 func WithErrorHandler(h ErrorHandler) OptionFunc {
 	return func(cfg *config) {
 		cfg.errorHandler = h
 	}
 }
+
+// WithBeforeBulkHook has associated a hook called before a worker sends a bulk request.
+func WithBeforeBulkHook(h BeforeBulkHook) OptionFunc {
+	return func(cfg *config) {
+		cfg.beforeBulkHook = h
+	}
+}
+
+// WithAfterBulkHook has associated a hook called after a worker receives a response of a bulk request.
+func WithAfterBulkHook(h AfterBulkHook) OptionFunc {
+	return func(cfg *config) {
+		cfg.afterBulkHook = h
+	}
+}
+
+// WithRetryMaxAttemptsOption has associated maximum count to send a failed item including the first try.
+// If it is less than 2, failed items are not retried.
+func WithRetryMaxAttemptsOption(attempts int) OptionFunc {
+	return func(cfg *config) {
+		cfg.retryMaxAttempts = attempts
+	}
+}
+
+// WithRetryBackoffOption has associated exponential backoff between retries.
+func WithRetryBackoffOption(initial, max time.Duration, multiplier float64) OptionFunc {
+	return func(cfg *config) {
+		cfg.retryInitialInterval = initial
+		cfg.retryMaxInterval = max
+		cfg.retryMultiplier = multiplier
+	}
+}
+
+// WithRetryJitterOption has associated randomization factor(0 ~ 1) of wait time between retries.
+func WithRetryJitterOption(jitter float64) OptionFunc {
+	return func(cfg *config) {
+		cfg.retryJitter = jitter
+	}
+}
+
+// WithRetryPolicyOption has associated a policy deciding which failed item is retried by its status.
+func WithRetryPolicyOption(policy RetryPolicy) OptionFunc {
+	return func(cfg *config) {
+		cfg.retryPolicy = policy
+	}
+}
+
+// WithDeadLetterSinkOption has associated a sink receiving actions which permanently failed.
+func WithDeadLetterSinkOption(sink DeadLetterSink) OptionFunc {
+	return func(cfg *config) {
+		cfg.deadLetterSink = sink
+	}
+}
+
+// WithWALOption has associated a directory of write-ahead log which keeps queued actions on a local disk.
+// actions remained in it are replayed when a dispatcher is started.
+func WithWALOption(dir string) OptionFunc {
+	return func(cfg *config) {
+		cfg.walDir = dir
+	}
+}
+
+// WithWALSegmentSizeOption has associated maximum bytes of a segment file in write-ahead log.
+func WithWALSegmentSizeOption(size int64) OptionFunc {
+	return func(cfg *config) {
+		cfg.walSegmentSize = size
+	}
+}
+
+// WithWALSyncOption has associated a policy when write-ahead log is flushed to a disk.
+// interval is only used with WAL_SYNC_INTERVAL.
+func WithWALSyncOption(policy WALSyncPolicy, interval time.Duration) OptionFunc {
+	return func(cfg *config) {
+		cfg.walSyncPolicy = policy
+		cfg.walSyncInterval = interval
+	}
+}
@@ -1,6 +1,7 @@
 package esworker
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -18,6 +19,27 @@ func TestWithESVersionOption(t *testing.T) {
 	assert.Equal(V5, cfg.version)
 }
 
+func TestESVersion_GetString(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input    ESVersion
+		output   string
+		typeless bool
+	}{
+		"v5":         {input: V5, output: "ES5.X"},
+		"v7":         {input: V7, output: "ES7.X"},
+		"v8":         {input: V8, output: "ES8.X", typeless: true},
+		"opensearch": {input: OpenSearch, output: "OpenSearch", typeless: true},
+		"unknown":    {input: ESVersion(-1), output: "unknown"},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, t.input.GetString())
+		assert.Equal(t.typeless, t.input.typeless())
+	}
+}
+
 func TestWithAddressesOption(t *testing.T) {
 	assert := assert.New(t)
 
@@ -84,6 +106,15 @@ func TestWithLoggerOption(t *testing.T) {
 	assert.Equal(logger, cfg.logger)
 }
 
+func TestWithBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithBackend(&mockBackend{})
+	f.apply(cfg)
+	assert.NotNil(cfg.backend)
+}
+
 func TestWithGlobalQueueSizeOption(t *testing.T) {
 	assert := assert.New(t)
 
@@ -120,6 +151,77 @@ func TestWithWorkerWaitInterval(t *testing.T) {
 	assert.Equal(time.Second*2, cfg.workerWaitInterval)
 }
 
+func TestWithWorkerMaxBulkBytesOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithWorkerMaxBulkBytesOption(1024)
+	f.apply(cfg)
+	assert.Equal(1024, cfg.workerMaxBulkBytes)
+}
+
+func TestWithPartitionOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithPartitionOption(PartitionByDocument)
+	f.apply(cfg)
+	assert.Equal("allan\x001", cfg.partition(&mockAction{index: "allan", id: "1"}))
+}
+
+func TestWithCoalesceOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithCoalesceOption(true)
+	f.apply(cfg)
+	assert.True(cfg.coalesce)
+}
+
+func TestWithOverflowPolicyOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithOverflowPolicyOption(OVERFLOW_DROP_OLDEST)
+	f.apply(cfg)
+	assert.Equal(OVERFLOW_DROP_OLDEST, cfg.overflowPolicy)
+}
+
+func TestWithOverflowSpillDirOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithOverflowSpillDirOption("spill")
+	f.apply(cfg)
+	assert.Equal("spill", cfg.overflowSpillDir)
+}
+
+func TestWithAdaptiveBatchOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithAdaptiveBatchOption(10, 1000, time.Millisecond, time.Second)
+	f.apply(cfg)
+	assert.True(cfg.adaptive)
+	assert.Equal(10, cfg.adaptiveMinSize)
+	assert.Equal(1000, cfg.adaptiveMaxSize)
+	assert.Equal(time.Millisecond, cfg.adaptiveMinInterval)
+	assert.Equal(time.Second, cfg.adaptiveMaxInterval)
+
+	_, err := NewDispatcher(WithAdaptiveBatchOption(0, 1000, time.Millisecond, time.Second))
+	assert.Error(err)
+}
+
+func TestWithAdaptiveTargetOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithAdaptiveTargetOption(time.Second, 1024)
+	f.apply(cfg)
+	assert.Equal(time.Second, cfg.adaptiveTargetLatency)
+	assert.Equal(1024, cfg.adaptiveTargetBytes)
+}
+
 func TestWithErrorHandler(t *testing.T) {
 	assert := assert.New(t)
 
@@ -130,3 +232,100 @@ func TestWithErrorHandler(t *testing.T) {
 	f.apply(cfg)
 	assert.NotEmpty(cfg.errorHandler)
 }
+
+func TestWithBeforeBulkHook(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithBeforeBulkHook(func(ctx context.Context, workerID int, acts []Action) {})
+	f.apply(cfg)
+	assert.NotEmpty(cfg.beforeBulkHook)
+}
+
+func TestWithAfterBulkHook(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithAfterBulkHook(func(ctx context.Context, workerID int, acts []Action, resp *ESResponseBulk, err error) {})
+	f.apply(cfg)
+	assert.NotEmpty(cfg.afterBulkHook)
+}
+
+func TestWithRetryMaxAttemptsOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithRetryMaxAttemptsOption(5)
+	f.apply(cfg)
+	assert.Equal(5, cfg.retryMaxAttempts)
+}
+
+func TestWithRetryBackoffOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithRetryBackoffOption(time.Second, time.Minute, 1.5)
+	f.apply(cfg)
+	assert.Equal(time.Second, cfg.retryInitialInterval)
+	assert.Equal(time.Minute, cfg.retryMaxInterval)
+	assert.Equal(1.5, cfg.retryMultiplier)
+}
+
+func TestWithRetryJitterOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithRetryJitterOption(0.3)
+	f.apply(cfg)
+	assert.Equal(0.3, cfg.retryJitter)
+}
+
+func TestWithRetryPolicyOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithRetryPolicyOption(RetryOnStatus(429))
+	f.apply(cfg)
+	assert.True(cfg.retryPolicy(429))
+	assert.False(cfg.retryPolicy(503))
+}
+
+func TestWithDeadLetterSinkOption(t *testing.T) {
+	assert := assert.New(t)
+
+	sink, err := NewMemoryDeadLetterSink(10)
+	assert.NoError(err)
+
+	cfg := &config{}
+	f := WithDeadLetterSinkOption(sink)
+	f.apply(cfg)
+	assert.Equal(sink, cfg.deadLetterSink)
+}
+
+func TestWithWALOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithWALOption("/tmp/wal")
+	f.apply(cfg)
+	assert.Equal("/tmp/wal", cfg.walDir)
+}
+
+func TestWithWALSegmentSizeOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithWALSegmentSizeOption(1024)
+	f.apply(cfg)
+	assert.Equal(int64(1024), cfg.walSegmentSize)
+}
+
+func TestWithWALSyncOption(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config{}
+	f := WithWALSyncOption(WAL_SYNC_INTERVAL, time.Second)
+	f.apply(cfg)
+	assert.Equal(WAL_SYNC_INTERVAL, cfg.walSyncPolicy)
+	assert.Equal(time.Second, cfg.walSyncInterval)
+}
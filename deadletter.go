@@ -0,0 +1,278 @@
+package esworker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an action which is not able to be delivered to the elasticsearch.
+type DeadLetter struct {
+	Action    Action          // original action.
+	Status    int             // status of a failed item (a status of a whole bulk request if it is failed, 0 if there is no response).
+	Error     ESResponseError // error of a failed item.
+	Attempts  int             // how many times the action has been sent.
+	Timestamp time.Time       // time when the action is given up.
+}
+
+// deadLetterRecord is a line of NDJSON to store a dead letter.
+type deadLetterRecord struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Operation string          `json:"operation"`
+	Index     string          `json:"index"`
+	DocType   string          `json:"doc_type,omitempty"`
+	Id        string          `json:"id,omitempty"`
+	Doc       json.RawMessage `json:"doc,omitempty"`
+	Meta      *ActionMeta     `json:"meta,omitempty"`
+	Status    int             `json:"status"`
+	Error     ESResponseError `json:"error"`
+	Attempts  int             `json:"attempts"`
+}
+
+// DeadLetterSink receives actions which permanently failed.
+type DeadLetterSink interface {
+	Put(letter *DeadLetter) error
+}
+
+// MarshalJSON converts a dead letter to JSON including a document of the action.
+func (dl *DeadLetter) MarshalJSON() ([]byte, error) {
+	record := &deadLetterRecord{
+		Timestamp: dl.Timestamp,
+		Status:    dl.Status,
+		Error:     dl.Error,
+		Attempts:  dl.Attempts,
+	}
+	if dl.Action != nil {
+		record.Operation = dl.Action.GetOperation().GetString()
+		record.Index = dl.Action.GetIndex()
+		record.DocType = dl.Action.GetDocType()
+		record.Id = dl.Action.GetID()
+		// a document is stored as it is sent since v6.
+		doc, err := encodeDoc(dl.Action, V6, defaultCodec)
+		if err != nil {
+			return nil, err
+		}
+		record.Doc = doc
+		record.Meta = getActionMeta(dl.Action)
+	}
+	return json.Marshal(record)
+}
+
+// UnmarshalJSON restores a dead letter from JSON, and its action is restored as RawAction to be replayed.
+func (dl *DeadLetter) UnmarshalJSON(data []byte) error {
+	record := &deadLetterRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return err
+	}
+
+	op, ok := parseESOperation(record.Operation)
+	if !ok {
+		return fmt.Errorf("[err] DeadLetter unknown operation %s", record.Operation)
+	}
+
+	dl.Action = &RawAction{
+		Op:      op,
+		Index:   record.Index,
+		DocType: record.DocType,
+		Id:      record.Id,
+		Doc:     record.Doc,
+		Meta:    record.Meta,
+	}
+	dl.Status = record.Status
+	dl.Error = record.Error
+	dl.Attempts = record.Attempts
+	dl.Timestamp = record.Timestamp
+	return nil
+}
+
+// LoadDeadLetters reads dead letters from NDJSON written by FileDeadLetterSink.
+func LoadDeadLetters(r io.Reader) ([]*DeadLetter, error) {
+	if r == nil {
+		return nil, fmt.Errorf("[err] LoadDeadLetters empty params")
+	}
+
+	var letters []*DeadLetter
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		letter := &DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), letter); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// MemoryDeadLetterSink keeps recent dead letters in a ring buffer.
+type MemoryDeadLetterSink struct {
+	sync.RWMutex
+	letters []*DeadLetter
+	next    int
+	full    bool
+}
+
+// Put stores a dead letter, and the oldest one is overwritten if the buffer is full.
+func (ms *MemoryDeadLetterSink) Put(letter *DeadLetter) error {
+	if letter == nil {
+		return fmt.Errorf("[err] MemoryDeadLetterSink Put empty params")
+	}
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.letters[ms.next] = letter
+	ms.next = (ms.next + 1) % len(ms.letters)
+	if ms.next == 0 {
+		ms.full = true
+	}
+	return nil
+}
+
+// Letters returns stored dead letters from the oldest.
+func (ms *MemoryDeadLetterSink) Letters() []*DeadLetter {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	if !ms.full {
+		return append([]*DeadLetter{}, ms.letters[:ms.next]...)
+	}
+	letters := make([]*DeadLetter, 0, len(ms.letters))
+	letters = append(letters, ms.letters[ms.next:]...)
+	letters = append(letters, ms.letters[:ms.next]...)
+	return letters
+}
+
+// Len returns a count of stored dead letters.
+func (ms *MemoryDeadLetterSink) Len() int {
+	ms.RLock()
+	defer ms.RUnlock()
+	if ms.full {
+		return len(ms.letters)
+	}
+	return ms.next
+}
+
+// NewMemoryDeadLetterSink is to make MemoryDeadLetterSink keeping the size of recent dead letters.
+func NewMemoryDeadLetterSink(size int) (*MemoryDeadLetterSink, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("[err] NewMemoryDeadLetterSink invalid size")
+	}
+	return &MemoryDeadLetterSink{letters: make([]*DeadLetter, size)}, nil
+}
+
+// FileDeadLetterSink writes dead letters to a file as NDJSON, and the file is rotated by size.
+type FileDeadLetterSink struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// Put appends a dead letter to the file.
+func (fs *FileDeadLetterSink) Put(letter *DeadLetter) error {
+	if letter == nil {
+		return fmt.Errorf("[err] FileDeadLetterSink Put empty params")
+	}
+
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	line = append(line, "\n"...)
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.file == nil {
+		return fmt.Errorf("[err] FileDeadLetterSink closed")
+	}
+
+	if fs.maxSize > 0 && fs.size > 0 && fs.size+int64(len(line)) > fs.maxSize {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := fs.file.Write(line)
+	fs.size += int64(n)
+	return err
+}
+
+// Close closes the file.
+func (fs *FileDeadLetterSink) Close() error {
+	fs.Lock()
+	defer fs.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
+
+// rotate moves the current file to a backup(path.1, path.2, ...) and opens a new file.
+func (fs *FileDeadLetterSink) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+	fs.file = nil
+
+	if fs.maxBackups > 0 {
+		for i := fs.maxBackups - 1; i > 0; i-- {
+			src := fmt.Sprintf("%s.%d", fs.path, i)
+			if _, err := os.Stat(src); err == nil {
+				if err := os.Rename(src, fmt.Sprintf("%s.%d", fs.path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(fs.path, fs.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(fs.path); err != nil {
+		return err
+	}
+	return fs.open()
+}
+
+// open opens the file to append.
+func (fs *FileDeadLetterSink) open() error {
+	file, err := os.OpenFile(fs.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	fs.file = file
+	fs.size = info.Size()
+	return nil
+}
+
+// NewFileDeadLetterSink is to make FileDeadLetterSink.
+// the file is rotated when it exceeds maxSize bytes(0 means no rotation), and maxBackups old files are kept.
+func NewFileDeadLetterSink(path string, maxSize int64, maxBackups int) (*FileDeadLetterSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("[err] NewFileDeadLetterSink empty params")
+	}
+
+	fs := &FileDeadLetterSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
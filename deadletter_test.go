@@ -0,0 +1,154 @@
+package esworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetter_JSON(t *testing.T) {
+	assert := assert.New(t)
+
+	letter := &DeadLetter{
+		Action: &mockAction{
+			op:      ES_CREATE,
+			index:   "allan",
+			docType: "_doc",
+			id:      "1",
+			doc:     map[string]interface{}{"field": "value"},
+		},
+		Status:    400,
+		Error:     ESResponseError{Type: "mapper_parsing_exception", Reason: "failed to parse"},
+		Attempts:  1,
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}
+
+	data, err := json.Marshal(letter)
+	assert.NoError(err)
+
+	restored := &DeadLetter{}
+	err = json.Unmarshal(data, restored)
+	assert.NoError(err)
+	assert.Equal(letter.Status, restored.Status)
+	assert.Equal(letter.Error, restored.Error)
+	assert.Equal(letter.Attempts, restored.Attempts)
+	assert.True(letter.Timestamp.Equal(restored.Timestamp))
+	assert.Equal(&RawAction{
+		Op:      ES_CREATE,
+		Index:   "allan",
+		DocType: "_doc",
+		Id:      "1",
+		Doc:     []byte(`{"field":"value"}`),
+	}, restored.Action)
+
+	// metadata is kept.
+	letter.Action = &UpdateAction{Index: "allan", Id: "1", Doc: map[string]interface{}{"field": "value"}, RetryOnConflict: 2}
+	data, err = json.Marshal(letter)
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, restored))
+	assert.Equal(&ActionMeta{RetryOnConflict: 2}, restored.Action.(*RawAction).Meta)
+
+	err = json.Unmarshal([]byte(`{"operation": "unknown"}`), restored)
+	assert.Error(err)
+}
+
+func TestLoadDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadDeadLetters(nil)
+	assert.Error(err)
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < 3; i++ {
+		data, err := json.Marshal(&DeadLetter{
+			Action: &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)},
+			Status: 400,
+		})
+		assert.NoError(err)
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+
+	letters, err := LoadDeadLetters(buf)
+	assert.NoError(err)
+	assert.Len(letters, 3)
+	assert.Equal("2", letters[2].Action.GetID())
+}
+
+func TestMemoryDeadLetterSink(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewMemoryDeadLetterSink(0)
+	assert.Error(err)
+
+	sink, err := NewMemoryDeadLetterSink(3)
+	assert.NoError(err)
+	assert.Error(sink.Put(nil))
+	assert.Equal(0, sink.Len())
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(sink.Put(&DeadLetter{Action: &mockAction{id: fmt.Sprintf("%d", i)}}))
+	}
+	assert.Equal(2, sink.Len())
+	assert.Equal("0", sink.Letters()[0].Action.GetID())
+
+	// the oldest is overwritten.
+	for i := 2; i < 5; i++ {
+		assert.NoError(sink.Put(&DeadLetter{Action: &mockAction{id: fmt.Sprintf("%d", i)}}))
+	}
+	assert.Equal(3, sink.Len())
+	letters := sink.Letters()
+	assert.Equal("2", letters[0].Action.GetID())
+	assert.Equal("3", letters[1].Action.GetID())
+	assert.Equal("4", letters[2].Action.GetID())
+}
+
+func TestFileDeadLetterSink(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-deadletter")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deadletter.ndjson")
+
+	_, err = NewFileDeadLetterSink("", 0, 0)
+	assert.Error(err)
+
+	sink, err := NewFileDeadLetterSink(path, 300, 2)
+	assert.NoError(err)
+	assert.Error(sink.Put(nil))
+
+	for i := 0; i < 10; i++ {
+		err := sink.Put(&DeadLetter{
+			Action: &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)},
+			Status: 400,
+		})
+		assert.NoError(err)
+	}
+	assert.NoError(sink.Close())
+	assert.Error(sink.Put(&DeadLetter{Action: &mockAction{}}))
+
+	// rotated files are kept up to max backups.
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		assert.NoError(err)
+		assert.True(info.Size() <= 300)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(os.IsNotExist(err))
+
+	f, err := os.Open(path)
+	assert.NoError(err)
+	defer f.Close()
+	letters, err := LoadDeadLetters(f)
+	assert.NoError(err)
+	assert.NotEmpty(letters)
+	assert.Equal("9", letters[len(letters)-1].Action.GetID())
+}
@@ -2,9 +2,11 @@ package esworker
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +15,16 @@ var (
 	defaultWorkerSize         = 5
 	defaultWorkerQueueSize    = 1000
 	defaultWorkerWaitInterval = time.Duration(2 * time.Second)
+	defaultSpillPollInterval  = time.Duration(10 * time.Millisecond)
+	defaultBulkFilterPath     = "errors,items.*.error,items.*.status,items.*._id,items.*._index,items.*.result,items.*._version,items.*._seq_no,items.*._primary_term"
+)
+
+// AddMode decides what AddActions does when some of actions are invalid.
+type AddMode int
+
+const (
+	ADD_ALL_OR_NOTHING AddMode = iota // add none of actions.
+	ADD_PARTIAL                       // add valid actions, and reject invalid ones.
 )
 
 type (
@@ -28,6 +40,13 @@ type (
 	// Dispatcher is an interface of workers orchestration that could manage all of Action and control all of the process flows.
 	Dispatcher interface {
 		AddAction(ctx context.Context, action Action) error
+		AddActions(ctx context.Context, actions []Action, mode AddMode) error
+		TryAddAction(action Action) error
+		AddActionWithResult(ctx context.Context, action Action) (*ActionResult, error)
+		Flush(ctx context.Context) (success int, fail int, err error)
+		SetWorkerCount(n int) error
+		Shutdown(ctx context.Context) ([]Action, error)
+		Stats() *Stats
 		Start() error
 		Stop() error
 	}
@@ -41,25 +60,132 @@ type (
 
 	// breaker is a middle struct between dispatcher and worker
 	breaker struct {
-		queue        chan Action
-		pool         chan chan Action
+		cfg          *config      // it is used to create workers at resizing.
+		queue        chan []*task // tasks added together are handed over to a worker as a unit.
+		queued       int64        // count of actions in queue.
+		pool         chan chan []*task
 		workers      []*worker
+		resizing     sync.RWMutex // SetWorkerCount holds it for writing while it replaces workers.
+		partitioner  *partitioner // it routes actions to workers by their keys (nil if any free worker takes them).
 		errorHandler ErrorHandler
+		wal          *wal
+		spill        *spill        // it keeps actions overflowing queue on a local disk (nil if not spilled).
+		restoring    []*task       // tasks taken from spill files, which are not pushed to queue yet.
+		restored     chan struct{} // it is closed when restoring spilled tasks stops.
+		flush        chan *flushRequest
+		resize       chan *resizeRequest
 		quit         chan bool
+		intake       sync.RWMutex  // AddAction holds it for reading while it pushes an action.
+		closing      chan struct{} // it is closed when shutdown begins.
 		running      bool
+		metrics      *metrics
+		ctx          context.Context    // it is canceled to abort in-flight bulks.
+		cancel       context.CancelFunc // cancel function of ctx.
 	}
 )
 
-// AddAction pushes an action to queue.
+// AddAction pushes an action to queue, and an overflow policy decides what to do when queue is full.
 func (dp *dispatcher) AddAction(ctx context.Context, action Action) error {
+	return dp.addTask(ctx, &task{act: action}, dp.cfg.overflowPolicy)
+}
+
+// TryAddAction pushes an action to queue without blocking.
+// if queue is full, it returns ErrQueueFull immediately with OVERFLOW_BLOCK, otherwise an overflow policy is applied.
+func (dp *dispatcher) TryAddAction(action Action) error {
+	policy := dp.cfg.overflowPolicy
+	if policy == OVERFLOW_BLOCK {
+		policy = OVERFLOW_REJECT
+	}
+	return dp.addTask(context.Background(), &task{act: action}, policy)
+}
+
+// AddActionWithResult pushes an action to queue, and returns a handle resolved with its result.
+func (dp *dispatcher) AddActionWithResult(ctx context.Context, action Action) (*ActionResult, error) {
+	t := &task{act: action, result: newActionResult()}
+	if err := dp.addTask(ctx, t, dp.cfg.overflowPolicy); err != nil {
+		return nil, err
+	}
+	return t.result, nil
+}
+
+// AddActions pushes actions to queue as a unit, so they are handed over to a worker at once.
+// with ADD_ALL_OR_NOTHING, none of actions is added if any of them is invalid.
+// with ADD_PARTIAL, valid actions are added and invalid ones are rejected.
+// if any of actions is rejected, it returns *AddActionsError which has the index of the first rejected action and errors by index.
+func (dp *dispatcher) AddActions(ctx context.Context, actions []Action, mode AddMode) (err error) {
+	accepted := 0
+	defer func() {
+		dp.bk.metrics.observeAdds(accepted, len(actions)-accepted)
+	}()
+
+	// shutdown waits for pushing actions in progress.
+	dp.bk.intake.RLock()
+	defer dp.bk.intake.RUnlock()
+	if !dp.bk.running {
+		return fmt.Errorf("[err] AddActions (dispatcher not running)")
+	}
+
+	if ctx == nil || len(actions) == 0 {
+		return fmt.Errorf("[err] AddActions (empty params)")
+	}
+
+	rejected := &AddActionsError{First: -1, Errors: make([]error, len(actions))}
+	tasks := make([]*task, 0, len(actions))
+	for i, action := range actions {
+		if action == nil {
+			rejected.reject(i, fmt.Errorf("[err] AddActions (empty action)"))
+			continue
+		}
+		if err := dp.validate(action); err != nil {
+			rejected.reject(i, err)
+			continue
+		}
+		tasks = append(tasks, &task{act: action})
+	}
+	if rejected.First >= 0 && (mode == ADD_ALL_OR_NOTHING || len(tasks) == 0) {
+		return rejected
+	}
+
+	if err := dp.enqueue(ctx, tasks, dp.cfg.overflowPolicy); err != nil {
+		for i := range actions {
+			if rejected.Errors[i] == nil {
+				rejected.reject(i, err)
+			}
+		}
+		return rejected
+	}
+	accepted = len(tasks)
+	if rejected.First >= 0 {
+		return rejected
+	}
+	return nil
+}
+
+// addTask validates an action and pushes it to queue.
+func (dp *dispatcher) addTask(ctx context.Context, t *task, policy OverflowPolicy) (err error) {
+	defer func() {
+		dp.bk.metrics.observeAdd(err)
+	}()
+
+	// shutdown waits for pushing an action in progress.
+	dp.bk.intake.RLock()
+	defer dp.bk.intake.RUnlock()
 	if !dp.bk.running {
 		return fmt.Errorf("[err] AddAction (dispatcher not running)")
 	}
 
-	if ctx == nil || action == nil {
+	if ctx == nil || t.act == nil {
 		return fmt.Errorf("[err] AddAction (empty params)")
 	}
 
+	if err := dp.validate(t.act); err != nil {
+		return err
+	}
+	return dp.enqueue(ctx, []*task{t}, policy)
+}
+
+// validate checks whether an action could be sent.
+func (dp *dispatcher) validate(action Action) error {
 	if action.GetIndex() == "" {
 		return fmt.Errorf("[err] AddAction (required index)")
 	}
@@ -68,20 +194,93 @@ func (dp *dispatcher) AddAction(ctx context.Context, action Action) error {
 		return fmt.Errorf("[err] AddAction (if an operation is a create, it is required id)")
 	}
 
+	// an invalid raw document would fail a whole bulk request.
+	if raw, ok := action.(RawDocAction); ok && len(raw.GetRawDoc()) > 0 && !json.Valid(raw.GetRawDoc()) {
+		return fmt.Errorf("[err] AddAction (invalid raw doc)")
+	}
+
+	// a body of an update given as a value is not validated.
 	if action.GetOperation() == ES_UPDATE {
-		if _, ok := action.GetDoc()["doc"]; !ok {
-			return fmt.Errorf("[err] AddAction (if an operation is a update, it is required doc key)")
+		hasDoc, hasScript, checked := updateKeys(action)
+		switch {
+		case !checked:
+		case !hasDoc && !hasScript:
+			return fmt.Errorf("[err] AddAction (if an operation is a update, it is required doc or script key)")
+		case hasDoc && hasScript:
+			return fmt.Errorf("[err] AddAction (if an operation is a update, doc and script keys could not be used together)")
 		}
 	}
 
-	select {
-	case dp.bk.queue <- action:
-	case <-ctx.Done():
-		return fmt.Errorf("[err] AddAction timeout")
+	if err := getActionMeta(action).validate(dp.cfg.version, action.GetOperation()); err != nil {
+		return fmt.Errorf("[err] AddAction %w", err)
+	}
+	return nil
+}
+
+// enqueue logs tasks to a write-ahead log, and pushes them to queue as a unit.
+// if they could not be pushed, none of them remains in a write-ahead log.
+func (dp *dispatcher) enqueue(ctx context.Context, tasks []*task, policy OverflowPolicy) error {
+	if dp.bk.wal != nil {
+		for i, t := range tasks {
+			seq, err := dp.bk.wal.append(t.act)
+			if err != nil {
+				for _, logged := range tasks[:i] {
+					dp.bk.wal.ack(logged.seq)
+				}
+				return fmt.Errorf("[err] AddAction (wal) %w", err)
+			}
+			t.seq = seq
+		}
+	}
+
+	if err := dp.bk.push(ctx, tasks, policy); err != nil {
+		for _, t := range tasks {
+			dp.bk.wal.ack(t.seq)
+		}
+		return err
 	}
 	return nil
 }
 
+// Flush sends all of pending actions immediately and blocks until all of bulks complete or ctx is done.
+// it returns how many actions succeeded and failed during flushing.
+func (dp *dispatcher) Flush(ctx context.Context) (success int, fail int, err error) {
+	dp.RLock()
+	defer dp.RUnlock()
+	if !dp.bk.running {
+		return 0, 0, fmt.Errorf("[err] Flush (dispatcher not running)")
+	}
+	if ctx == nil {
+		return 0, 0, fmt.Errorf("[err] Flush (empty params)")
+	}
+	return dp.bk.flushAll(ctx)
+}
+
+// SetWorkerCount changes a count of workers while running or not.
+// new workers are started with their own clients, and retired workers stop after sending actions in their queues.
+func (dp *dispatcher) SetWorkerCount(n int) error {
+	dp.Lock()
+	defer dp.Unlock()
+	if n <= 0 {
+		return fmt.Errorf("[err] SetWorkerCount (invalid count)")
+	}
+	return dp.bk.setWorkerCount(n)
+}
+
+// Stats returns a snapshot of counters and gauges.
+func (dp *dispatcher) Stats() *Stats {
+	stats := dp.bk.metrics.snapshot()
+	stats.QueueDepth = dp.bk.queueDepth()
+	stats.SpillDepth = dp.bk.spill.len()
+	dp.bk.resizing.RLock()
+	defer dp.bk.resizing.RUnlock()
+	stats.WorkerQueueDepth = make([]int, 0, len(dp.bk.workers))
+	for _, w := range dp.bk.workers {
+		stats.WorkerQueueDepth = append(stats.WorkerQueueDepth, w.queueSize())
+	}
+	return stats
+}
+
 // Start is starting to let an action processed.
 func (dp *dispatcher) Start() error {
 	dp.Lock()
@@ -89,8 +288,7 @@ func (dp *dispatcher) Start() error {
 	if dp.bk.running {
 		return fmt.Errorf("[err] already runnning dispatcher\n")
 	}
-	dp.bk.start()
-	return nil
+	return dp.bk.start()
 }
 
 // Stop is stopping to let an action don't be processed.
@@ -100,45 +298,176 @@ func (dp *dispatcher) Stop() error {
 	if !dp.bk.running {
 		return fmt.Errorf("[err] already stop dispatcher\n")
 	}
-	dp.bk.stop()
-	return nil
+	_, err := dp.bk.shutdown(context.Background())
+	return err
+}
+
+// Shutdown stops to accept actions and sends pending actions until ctx is done.
+// if ctx is done before all of them are delivered, in-flight bulks are aborted and
+// actions which could not be delivered are returned with ctx error.
+// if a write-ahead log is enabled, they are not returned because they remain in it to be replayed at the next Start.
+func (dp *dispatcher) Shutdown(ctx context.Context) ([]Action, error) {
+	dp.Lock()
+	defer dp.Unlock()
+	if !dp.bk.running {
+		return nil, fmt.Errorf("[err] already stop dispatcher\n")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("[err] Shutdown (empty params)")
+	}
+	return dp.bk.shutdown(ctx)
 }
 
-func (bk *breaker) start() {
+func (bk *breaker) start() error {
+	// actions remained in a write-ahead log are replayed.
+	var replay []Action
+	if bk.wal != nil {
+		acts, err := bk.wal.open()
+		if err != nil {
+			return err
+		}
+		replay = acts
+	}
+
+	bk.ctx, bk.cancel = context.WithCancel(context.Background())
+	bk.closing = make(chan struct{})
+	if bk.spill != nil {
+		if err := bk.spill.open(); err != nil {
+			return err
+		}
+		bk.restoring = nil
+		bk.restored = make(chan struct{})
+		go bk.restore(bk.restored)
+	}
+	bk.intake.Lock()
 	bk.running = true
+	bk.intake.Unlock()
 	for _, w := range bk.workers {
+		w.ctx = bk.ctx
 		go w.start()
 	}
 	go bk.booking()
+
+	if len(replay) == 0 {
+		return nil
+	}
+	for _, act := range replay {
+		seq, err := bk.wal.append(act)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&bk.queued, 1)
+		bk.queue <- []*task{{act: act, seq: seq}}
+	}
+	return bk.wal.removeStale()
 }
 
-func (bk *breaker) stop() {
+func (bk *breaker) shutdown(ctx context.Context) ([]Action, error) {
+	// stop intake, and wait for AddAction in progress.
+	// after that, nothing is pushed to queue.
+	close(bk.closing)
+	bk.intake.Lock()
 	bk.running = false
-	// wait until all data in queue are consumed.
-	if len(bk.queue) > 0 {
-	Empty:
-		for {
-			select {
-			case <-time.After(100 * time.Millisecond):
-				if len(bk.queue) == 0 {
-					break Empty
-				}
-			}
-		}
+	bk.intake.Unlock()
+	if bk.restored != nil {
+		<-bk.restored
 	}
 
+	// in-flight bulks are aborted when ctx is done.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			bk.cancel()
+		case <-stopped:
+		}
+	}()
+
 	// stop breaker
 	bk.quit <- true
 
-	// stop worker
+	// hand over actions remained in queue and spill files to workers through their pipes.
+	var undelivered []*task
+	for tasks := bk.pop(); tasks != nil; tasks = bk.pop() {
+		if rest := bk.handOver(ctx, tasks); len(rest) > 0 {
+			undelivered = append(undelivered, rest...)
+			break
+		}
+	}
+
+	// stop worker after processing rest jobs.
+	wg := sync.WaitGroup{}
 	for _, w := range bk.workers {
-		w.stop()
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.stop()
+		}(w)
 	}
+	wg.Wait()
 
 	// delete all workers to be waiting
 	for len(bk.pool) > 0 {
 		<-bk.pool
 	}
+
+	// collect actions which could not be delivered.
+	// if a write-ahead log is enabled, they remain in it to be replayed instead of being returned.
+	for tasks := bk.pop(); tasks != nil; tasks = bk.pop() {
+		undelivered = append(undelivered, tasks...)
+	}
+	for _, w := range bk.workers {
+		undelivered = append(undelivered, w.dequeueAll()...)
+	}
+	var acts []Action
+	for _, t := range undelivered {
+		t.result.resolve(nil, fmt.Errorf("[err] Shutdown (undelivered)"))
+		for _, f := range t.folded {
+			f.result.resolve(nil, fmt.Errorf("[err] Shutdown (undelivered)"))
+		}
+		if bk.wal == nil {
+			acts = append(acts, t.act)
+		}
+	}
+
+	if err := bk.spill.close(); err != nil {
+		bk.errorHandler(err)
+	}
+	if err := bk.wal.close(); err != nil {
+		bk.errorHandler(err)
+	}
+	bk.cancel()
+
+	if len(undelivered) > 0 {
+		return acts, ctx.Err()
+	}
+	return nil, nil
+}
+
+// flushAll asks the booking loop to hand over queued actions and to flush all of workers.
+func (bk *breaker) flushAll(ctx context.Context) (success int, fail int, err error) {
+	req := &flushRequest{ctx: ctx}
+	req.wg.Add(len(bk.workers))
+
+	select {
+	case bk.flush <- req:
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		req.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return int(atomic.LoadInt64(&req.success)), int(atomic.LoadInt64(&req.fail)), err
 }
 
 func (bk *breaker) booking() {
@@ -154,17 +483,80 @@ func (bk *breaker) booking() {
 Loop:
 	for {
 		select {
-		case act := <-bk.queue: // pop action
-			// get worker pipe
-			workerPipe := <-bk.pool
-			// send action to worker pipe
-			workerPipe <- act
+		case tasks := <-bk.queue: // pop action
+			bk.received(tasks)
+			bk.handOver(context.Background(), tasks)
+		case req := <-bk.flush: // flush workers
+			// hand over actions queued before flushing.
+			for n := len(bk.queue); n > 0; n-- {
+				tasks := <-bk.queue
+				bk.received(tasks)
+				bk.handOver(context.Background(), tasks)
+			}
+			// hand over spilled actions as they are restored to queue.
+			// they are counted as spilled until they are pushed to queue, so queue is checked after spill files.
+			for req.ctx.Err() == nil && (bk.spill.len() > 0 || (bk.spill != nil && len(bk.queue) > 0)) {
+				select {
+				case tasks := <-bk.queue:
+					bk.received(tasks)
+					bk.handOver(context.Background(), tasks)
+				case <-time.After(defaultSpillPollInterval): // the last restored action might be received before it is uncounted.
+				case <-req.ctx.Done():
+				}
+			}
+			for _, w := range bk.workers {
+				select {
+				case w.flush <- req:
+				case <-req.ctx.Done():
+					req.wg.Done()
+				}
+			}
+		case req := <-bk.resize: // resize workers
+			bk.resizeWorkers(req)
+			close(req.done)
 		case <-bk.quit: // exit breaker
 			break Loop
 		}
 	}
 }
 
+// handOver sends tasks to worker pipes, and returns tasks which are not sent because ctx is done.
+// tasks go to any free worker at once, or in a partitioning mode, to workers decided by their keys.
+func (bk *breaker) handOver(ctx context.Context, tasks []*task) []*task {
+	if bk.partitioner != nil {
+		// tasks are grouped by workers, keeping an order of them.
+		groups := make([][]*task, len(bk.workers))
+		for _, t := range tasks {
+			i := bk.partitioner.route(t, len(bk.workers))
+			groups[i] = append(groups[i], t)
+		}
+		var rest []*task
+		for i, group := range groups {
+			if len(group) == 0 {
+				continue
+			}
+			if rest != nil {
+				rest = append(rest, group...)
+				continue
+			}
+			select {
+			case bk.workers[i].pipe <- group:
+			case <-ctx.Done():
+				rest = append([]*task{}, group...)
+			}
+		}
+		return rest
+	}
+
+	select {
+	case workerPipe := <-bk.pool:
+		workerPipe <- tasks
+		return nil
+	case <-ctx.Done():
+		return tasks
+	}
+}
+
 // NewDispatcher is to make Dispatcher.
 func NewDispatcher(opts ...Option) (Dispatcher, error) {
 	cfg := &config{}
@@ -172,6 +564,8 @@ func NewDispatcher(opts ...Option) (Dispatcher, error) {
 	o := []Option{
 		WithESVersionOption(V6), // default ES Version as v6
 		WithTransportOption(http.DefaultTransport),
+		WithCodecOption(defaultCodec),
+		WithBulkFilterPathOption(defaultBulkFilterPath),
 		WithGlobalQueueSizeOption(defaultGlobalQueueSize),
 		WithWorkerSizeOption(defaultWorkerSize),
 		WithWorkerQueueSizeOption(defaultWorkerQueueSize),
@@ -179,6 +573,13 @@ func NewDispatcher(opts ...Option) (Dispatcher, error) {
 		WithErrorHandler(func(err error) {
 			fmt.Printf("[err] %+v\n", err)
 		}),
+		WithAdaptiveTargetOption(defaultAdaptiveTargetLatency, defaultAdaptiveTargetBytes),
+		WithRetryMaxAttemptsOption(defaultRetryMaxAttempts),
+		WithRetryBackoffOption(defaultRetryInitialInterval, defaultRetryMaxInterval, defaultRetryMultiplier),
+		WithRetryJitterOption(defaultRetryJitter),
+		WithRetryPolicyOption(defaultRetryPolicy),
+		WithWALSegmentSizeOption(defaultWALSegmentSize),
+		WithWALSyncOption(WAL_SYNC_INTERVAL, defaultWALSyncInterval),
 	}
 
 	o = append(o, opts...)
@@ -199,32 +600,93 @@ func createBreaker(cfg *config) (*breaker, error) {
 		return nil, fmt.Errorf("[err] createBreaker empty params")
 	}
 
-	pool := make(chan chan Action, cfg.workerSize)
+	var l *wal
+	if cfg.walDir != "" {
+		created, err := createWAL(cfg)
+		if err != nil {
+			return nil, err
+		}
+		l = created
+	}
+
+	var s *spill
+	if cfg.overflowPolicy == OVERFLOW_SPILL {
+		created, err := createSpill(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s = created
+	}
+
+	// in a partitioning mode, workers are not waiting in a pool because a task goes to a worker decided by its key.
+	// a pipe is buffered instead, so a busy worker doesn't block tasks of other workers until it is full.
+	m := newMetrics()
+	pool := make(chan chan []*task, cfg.workerSize)
+	var p *partitioner
+	if cfg.partition != nil {
+		pool = nil
+		p = &partitioner{key: cfg.partition}
+	}
 	workers := make([]*worker, 0, cfg.workerSize)
 	for i := 0; i < cfg.workerSize; i++ {
-		client, err := createESProxy(cfg)
+		w, err := createWorker(cfg, i, pool, l, m)
 		if err != nil {
 			return nil, err
 		}
-		w := &worker{
-			id:           i,
-			pool:         pool,
-			pipe:         make(chan Action),
-			maxQueueSize: cfg.workerQueueSize,
-			waitInterval: cfg.workerWaitInterval,
-			quit:         make(chan bool),
-			errorHandler: cfg.errorHandler,
-			esClient:     client,
-		}
 		workers = append(workers, w)
 	}
 
 	return &breaker{
-		queue:        make(chan Action, cfg.globalQueueSize),
+		cfg:          cfg,
+		queue:        make(chan []*task, cfg.globalQueueSize),
 		pool:         pool,
 		workers:      workers,
+		partitioner:  p,
 		errorHandler: cfg.errorHandler,
+		wal:          l,
+		spill:        s,
+		metrics:      m,
+		flush:        make(chan *flushRequest),
+		resize:       make(chan *resizeRequest),
 		quit:         make(chan bool),
 		running:      false,
 	}, nil
 }
+
+// createWorker is to make worker with its own client.
+// in a partitioning mode, a pipe is buffered instead of being inserted to a pool.
+func createWorker(cfg *config, id int, pool chan chan []*task, l *wal, m *metrics) (*worker, error) {
+	client, err := createESProxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	adaptive, err := createBatchController(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pipeSize := 0
+	if cfg.partition != nil {
+		pipeSize = cfg.workerQueueSize
+	}
+	return &worker{
+		id:           id,
+		pool:         pool,
+		pipe:         make(chan []*task, pipeSize),
+		flush:        make(chan *flushRequest),
+		maxQueueSize: cfg.workerQueueSize,
+		maxBulkBytes: cfg.workerMaxBulkBytes,
+		waitInterval: cfg.workerWaitInterval,
+		quit:         make(chan bool),
+		errorHandler: cfg.errorHandler,
+		beforeBulk:   cfg.beforeBulkHook,
+		afterBulk:    cfg.afterBulkHook,
+		esClient:     client,
+		retry:        createRetrier(cfg),
+		adaptive:     adaptive,
+		partition:    cfg.partition,
+		coalesce:     cfg.coalesce,
+		deadLetter:   cfg.deadLetterSink,
+		wal:          l,
+		metrics:      m,
+	}, nil
+}
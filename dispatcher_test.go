@@ -2,10 +2,16 @@ package esworker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -109,6 +115,17 @@ func TestNewDispatcher(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(d.(*dispatcher).bk.workers[0].waitInterval, (5 * time.Second))
 
+	// fields of an item used by results are not filtered out by default.
+	filtered := map[string]bool{}
+	for _, path := range strings.Split(d.(*dispatcher).cfg.bulkFilterPath, ",") {
+		filtered[path] = true
+	}
+	status := reflect.TypeOf(bulkSuccessStatus{})
+	for i := 0; i < status.NumField(); i++ {
+		if tag := status.Field(i).Tag.Get("json"); tag != "_type" {
+			assert.True(filtered["items.*."+tag], tag)
+		}
+	}
 }
 
 func TestDispatcher_AddAction(t *testing.T) {
@@ -136,6 +153,24 @@ func TestDispatcher_AddAction(t *testing.T) {
 		"ok": {input: &mockAction{index: "allan", op: ES_UPDATE, doc: map[string]interface{}{
 			"doc": "aaa",
 		}}, isError: false},
+		"update and doc and script": {input: &UpdateAction{Index: "allan", Id: "1",
+			Doc: map[string]interface{}{"a": 1}, Script: &Script{Source: "ctx._source.a++"}}, isError: true},
+		"update and only upsert": {input: &UpdateAction{Index: "allan", Id: "1",
+			Upsert: map[string]interface{}{"a": 1}}, isError: true},
+		"unsupported meta": {input: &StandardAction{Op: ES_INDEX, Index: "allan",
+			Doc: map[string]interface{}{"a": 1}, Meta: &ActionMeta{RequireAlias: true}}, isError: true},
+		"update script": {input: &UpdateAction{Index: "allan", Id: "1",
+			Script: &Script{Source: "ctx._source.a++"}, Upsert: map[string]interface{}{"a": 1}}, isError: false},
+		"invalid raw doc": {input: &RawAction{Op: ES_INDEX, Index: "allan", Doc: []byte(`{"a":`)}, isError: true},
+		"raw doc":         {input: &RawAction{Op: ES_INDEX, Index: "allan", Doc: []byte(`{"a": 1}`)}, isError: false},
+		"raw update and no doc": {input: &RawAction{Op: ES_UPDATE, Index: "allan", Id: "1",
+			Doc: []byte(`{"upsert": {"a": 1}}`)}, isError: true},
+		"raw update and doc and script": {input: &RawAction{Op: ES_UPDATE, Index: "allan", Id: "1",
+			Doc: []byte(`{"doc": {"a": 1}, "script": {"source": "ctx._source.a++"}}`)}, isError: true},
+		"raw update": {input: &RawAction{Op: ES_UPDATE, Index: "allan", Id: "1",
+			Doc: []byte(`{"doc": {"a": 1}}`)}, isError: false},
+		"value update is not checked": {input: &mockValueAction{mockAction: mockAction{index: "allan", op: ES_UPDATE, id: "1"},
+			value: &mockDocument{Field1: 1}}, isError: false},
 	}
 
 	for _, t := range tests {
@@ -148,6 +183,460 @@ func TestDispatcher_AddAction(t *testing.T) {
 	}
 }
 
+func TestDispatcher_TryAddAction(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(1), WithWorkerQueueSizeOption(1), WithGlobalQueueSizeOption(1))
+	assert.NoError(err)
+	proxy := &mockProxy{delay: time.Second, bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	d.(*dispatcher).bk.workers[0].esClient = proxy
+
+	act := &mockAction{op: ES_INDEX, index: "allan", id: "1"}
+	assert.Error(d.TryAddAction(act))
+	assert.NoError(d.Start())
+
+	// it doesn't block while a worker is busy.
+	assert.Error(d.TryAddAction(&mockAction{op: ES_INDEX}))
+	var full error
+	for i := 0; i < 10 && full == nil; i++ {
+		full = d.TryAddAction(act)
+		time.Sleep(10 * time.Millisecond)
+	}
+	start := time.Now()
+	assert.Equal(ErrQueueFull, d.TryAddAction(act))
+	assert.True(time.Since(start) < 100*time.Millisecond)
+	assert.Equal(int64(4), d.Stats().Rejected)
+
+	// AddAction blocks until ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(errors.Is(d.AddAction(ctx, act), ErrQueueFull))
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	d.Shutdown(timeout)
+}
+
+func TestDispatcher_AddActions(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(1), WithWorkerQueueSizeOption(3))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	d.(*dispatcher).bk.workers[0].esClient = proxy
+
+	ctx := context.Background()
+	act := &mockAction{op: ES_INDEX, index: "allan", id: "1"}
+	assert.Error(d.AddActions(ctx, []Action{act}, ADD_PARTIAL))
+	assert.NoError(d.Start())
+	assert.Error(d.AddActions(ctx, nil, ADD_PARTIAL))
+
+	actions := []Action{
+		&mockAction{op: ES_INDEX, index: "allan", id: "p0"},
+		&mockAction{op: ES_INDEX},
+		&mockAction{op: ES_INDEX, index: "allan", id: "p2"},
+		nil,
+	}
+
+	// none of actions is added if any of them is invalid.
+	err = d.AddActions(ctx, actions, ADD_ALL_OR_NOTHING)
+	rejected := &AddActionsError{}
+	assert.True(errors.As(err, &rejected))
+	assert.Equal(1, rejected.First)
+	assert.Nil(rejected.Errors[0])
+	assert.Error(rejected.Errors[1])
+	assert.Nil(rejected.Errors[2])
+	assert.Error(rejected.Errors[3])
+	assert.Equal(int64(0), d.Stats().Accepted)
+	assert.Equal(int64(5), d.Stats().Rejected)
+
+	// valid actions are added, and they are sent together.
+	err = d.AddActions(ctx, actions, ADD_PARTIAL)
+	assert.True(errors.As(err, &rejected))
+	assert.Equal(1, rejected.First)
+	assert.Equal(int64(2), d.Stats().Accepted)
+	assert.Equal(int64(7), d.Stats().Rejected)
+	success, _, err := d.Flush(ctx)
+	assert.NoError(err)
+	assert.Equal(2, success)
+
+	// a worker takes actions at once, and sends them when its queue is full.
+	assert.NoError(d.AddActions(ctx, []Action{
+		&mockAction{op: ES_INDEX, index: "allan", id: "o0"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "o1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "o2"},
+	}, ADD_ALL_OR_NOTHING))
+	assert.NoError(d.Stop())
+
+	var ids [][]string
+	for _, call := range proxy.calls {
+		var callIDs []string
+		for _, act := range call {
+			callIDs = append(callIDs, act.GetID())
+		}
+		ids = append(ids, callIDs)
+	}
+	assert.Equal([][]string{{"p0", "p2"}, {"o0", "o1", "o2"}}, ids)
+
+	// actions which could not be pushed to queue are rejected.
+	d, err = NewDispatcher(WithGlobalQueueSizeOption(1), WithOverflowPolicyOption(OVERFLOW_REJECT))
+	assert.NoError(err)
+	d.(*dispatcher).bk.running = true
+	assert.NoError(d.AddActions(ctx, []Action{act, act}, ADD_PARTIAL))
+	err = d.AddActions(ctx, []Action{&mockAction{op: ES_INDEX}, act}, ADD_PARTIAL)
+	assert.True(errors.As(err, &rejected))
+	assert.Equal(0, rejected.First)
+	assert.True(errors.Is(rejected.Errors[1], ErrQueueFull))
+	assert.Equal(2, d.Stats().QueueDepth)
+}
+
+func TestDispatcher_Spill(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-overflow")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(1), WithWorkerQueueSizeOption(5), WithGlobalQueueSizeOption(1),
+		WithOverflowPolicyOption(OVERFLOW_SPILL), WithOverflowSpillDirOption(dir))
+	assert.NoError(err)
+	proxy := &mockProxy{delay: 5 * time.Millisecond, bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	d.(*dispatcher).bk.workers[0].esClient = proxy
+	assert.NoError(d.Start())
+
+	// actions overflowing queue are spilled, and they are delivered in order.
+	spilled := false
+	for i := 0; i < 50; i++ {
+		assert.NoError(d.TryAddAction(&mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+		spilled = spilled || d.Stats().SpillDepth > 0
+	}
+	assert.True(spilled)
+	assert.NoError(d.Stop())
+	assert.Equal(int64(50), d.Stats().Succeeded())
+	assert.Equal(0, d.Stats().SpillDepth)
+	assert.Len(spillSegments(dir), 0)
+
+	var ids []string
+	for _, call := range proxy.calls {
+		for _, act := range call {
+			ids = append(ids, act.GetID())
+		}
+	}
+	for i, id := range ids {
+		assert.Equal(fmt.Sprintf("%d", i), id)
+	}
+
+	// it could be restarted.
+	assert.NoError(d.Start())
+	assert.NoError(d.AddAction(context.Background(), &mockAction{op: ES_INDEX, index: "allan", id: "50"}))
+
+	// flushing sends spilled actions as well.
+	spilled = false
+	for i := 51; i < 100; i++ {
+		assert.NoError(d.TryAddAction(&mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+		spilled = spilled || d.Stats().SpillDepth > 0
+	}
+	assert.True(spilled)
+	_, _, err = d.Flush(context.Background())
+	assert.NoError(err)
+	assert.Equal(int64(100), d.Stats().Succeeded())
+	assert.Equal(0, d.Stats().SpillDepth)
+	assert.Equal(0, d.Stats().QueueDepth)
+
+	assert.NoError(d.Stop())
+	assert.Equal(int64(100), d.Stats().Succeeded())
+}
+
+func TestDispatcher_AddActionWithResult(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDispatcher(WithWorkerWaitInterval(10 * time.Millisecond))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = proxy
+	}
+
+	ctx := context.Background()
+	_, err = d.AddActionWithResult(ctx, &mockAction{op: ES_INDEX, index: "allan", id: "1"})
+	assert.Error(err)
+
+	assert.NoError(d.Start())
+	defer d.Stop()
+
+	_, err = d.AddActionWithResult(ctx, &mockAction{op: ES_INDEX})
+	assert.Error(err)
+
+	result, err := d.AddActionWithResult(ctx, &mockAction{op: ES_INDEX, index: "allan", id: "1"})
+	assert.NoError(err)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	status, err := result.Wait(waitCtx)
+	assert.NoError(err)
+	assert.Equal("1", status.Id)
+	assert.Equal(201, status.Status)
+}
+
+func TestDispatcher_Flush(t *testing.T) {
+	assert := assert.New(t)
+
+	// actions are not sent until flushing.
+	d, err := NewDispatcher(WithWorkerSizeOption(3), WithWorkerWaitInterval(time.Hour))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		statuses := make([]int, len(acts))
+		for i, act := range acts {
+			statuses[i] = 201
+			if act.GetID() == "0" {
+				statuses[i] = 400
+			}
+		}
+		return mockBulkResponse(acts, statuses...), nil
+	}}
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = proxy
+	}
+
+	ctx := context.Background()
+	_, _, err = d.Flush(ctx)
+	assert.Error(err)
+
+	assert.NoError(d.Start())
+	defer d.Stop()
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	assert.Equal(0, proxy.callCount())
+
+	success, fail, err := d.Flush(ctx)
+	assert.NoError(err)
+	assert.Equal(99, success)
+	assert.Equal(1, fail)
+	for _, w := range d.(*dispatcher).bk.workers {
+		assert.Equal(0, w.queueSize())
+	}
+
+	// nothing to flush.
+	success, fail, err = d.Flush(ctx)
+	assert.NoError(err)
+	assert.Equal(0, success)
+	assert.Equal(0, fail)
+
+	// timeout
+	proxy.Lock()
+	proxy.delay = 500 * time.Millisecond
+	proxy.Unlock()
+	assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: "100"}))
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, _, err = d.Flush(timeout)
+	assert.Equal(context.DeadlineExceeded, err)
+}
+
+func TestDispatcher_Partition(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(4), WithWorkerQueueSizeOption(7), WithWorkerWaitInterval(time.Hour),
+		WithPartitionOption(PartitionByDocument))
+	assert.NoError(err)
+	bk := d.(*dispatcher).bk
+	assert.Nil(bk.pool)
+	proxies := make([]*mockProxy, len(bk.workers))
+	for i, w := range bk.workers {
+		proxies[i] = &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+			return mockBulkResponse(acts), nil
+		}}
+		w.esClient = proxies[i]
+	}
+
+	ctx := context.Background()
+	assert.NoError(d.Start())
+	// actions added together are divided into workers by their keys.
+	var batch []Action
+	for i := 0; i < 200; i++ {
+		act := &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i%10), doc: map[string]interface{}{"seq": i}}
+		if i%3 == 0 {
+			act.op = ES_DELETE
+		}
+		if batch = append(batch, act); len(batch) == 20 {
+			assert.NoError(d.AddActions(ctx, batch, ADD_ALL_OR_NOTHING))
+			batch = nil
+		}
+	}
+	_, _, err = d.Flush(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(200), d.Stats().Succeeded())
+
+	// actions of an id are processed by a worker in order.
+	owners := map[string]int{}
+	last := map[string]int{}
+	for i, proxy := range proxies {
+		for _, call := range proxy.calls {
+			for _, act := range call {
+				owner, ok := owners[act.GetID()]
+				assert.True(!ok || owner == i)
+				owners[act.GetID()] = i
+				seq := act.GetDoc()["seq"].(int)
+				if prev, ok := last[act.GetID()]; ok {
+					assert.True(prev < seq)
+				}
+				last[act.GetID()] = seq
+			}
+		}
+	}
+	assert.Len(owners, 10)
+
+	// actions buffered in pipes are delivered at stopping.
+	for i := 0; i < 20; i++ {
+		assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	assert.NoError(d.Stop())
+	assert.Equal(int64(220), d.Stats().Succeeded())
+}
+
+func TestDispatcher_SetWorkerCount(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	senders := map[int]bool{}
+	d, err := NewDispatcher(WithBackend(&mockBackend{}), WithWorkerSizeOption(1), WithWorkerQueueSizeOption(10),
+		WithWorkerWaitInterval(time.Hour),
+		WithBeforeBulkHook(func(ctx context.Context, workerID int, acts []Action) {
+			mu.Lock()
+			senders[workerID] = true
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+		}))
+	assert.NoError(err)
+	assert.Error(d.SetWorkerCount(0))
+
+	ctx := context.Background()
+	batch := func(n int) []Action {
+		acts := make([]Action, 0, n)
+		for i := 0; i < n; i++ {
+			acts = append(acts, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)})
+		}
+		return acts
+	}
+	assert.NoError(d.Start())
+	assert.NoError(d.AddActions(ctx, batch(5), ADD_ALL_OR_NOTHING))
+
+	// added workers take actions while others are busy.
+	assert.NoError(d.SetWorkerCount(3))
+	assert.Len(d.Stats().WorkerQueueDepth, 3)
+	for i := 0; i < 3; i++ {
+		assert.NoError(d.AddActions(ctx, batch(10), ADD_ALL_OR_NOTHING))
+	}
+	_, _, err = d.Flush(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(35), d.Stats().Succeeded())
+	assert.Equal(map[int]bool{0: true, 1: true, 2: true}, senders)
+
+	// retired workers send actions in their queues before stopping.
+	for i := 0; i < 3; i++ {
+		assert.NoError(d.AddActions(ctx, batch(5), ADD_ALL_OR_NOTHING))
+	}
+	assert.NoError(d.SetWorkerCount(1))
+	assert.Len(d.Stats().WorkerQueueDepth, 1)
+	assert.NoError(d.AddActions(ctx, batch(10), ADD_ALL_OR_NOTHING))
+	assert.NoError(d.Stop())
+	assert.Equal(int64(60), d.Stats().Succeeded())
+
+	// workers could be resized while stopped.
+	assert.NoError(d.SetWorkerCount(2))
+	assert.NoError(d.Start())
+	assert.NoError(d.AddActions(ctx, batch(10), ADD_ALL_OR_NOTHING))
+	assert.NoError(d.Stop())
+	assert.Equal(int64(70), d.Stats().Succeeded())
+
+	// in a partitioning mode, actions of an id are processed in order across resizing.
+	var sent []Action
+	d, err = NewDispatcher(WithBackend(&mockBackend{}), WithWorkerSizeOption(2), WithWorkerQueueSizeOption(7),
+		WithWorkerWaitInterval(time.Hour), WithPartitionOption(PartitionByDocument),
+		WithBeforeBulkHook(func(ctx context.Context, workerID int, acts []Action) {
+			mu.Lock()
+			sent = append(sent, acts...)
+			mu.Unlock()
+		}))
+	assert.NoError(err)
+	assert.NoError(d.Start())
+	for i := 0; i < 150; i++ {
+		assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i%10),
+			doc: map[string]interface{}{"seq": i}}))
+		switch i {
+		case 50:
+			assert.NoError(d.SetWorkerCount(5))
+		case 100:
+			assert.NoError(d.SetWorkerCount(3))
+		}
+	}
+	assert.NoError(d.Stop())
+	assert.Equal(int64(150), d.Stats().Succeeded())
+	assert.Len(sent, 150)
+	last := map[string]int{}
+	for _, act := range sent {
+		seq := act.GetDoc()["seq"].(int)
+		if prev, ok := last[act.GetID()]; ok {
+			assert.True(prev < seq)
+		}
+		last[act.GetID()] = seq
+	}
+}
+
+func TestDispatcher_Stats(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(2), WithWorkerWaitInterval(time.Hour))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		statuses := make([]int, len(acts))
+		for i, act := range acts {
+			statuses[i] = 201
+			if act.GetID() == "1" || act.GetID() == "3" {
+				statuses[i] = 400
+			}
+		}
+		return mockBulkResponse(acts, statuses...), nil
+	}}
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = proxy
+	}
+
+	ctx := context.Background()
+	assert.NoError(d.Start())
+	defer d.Stop()
+
+	assert.Error(d.AddAction(ctx, &mockAction{op: ES_INDEX}))
+	for i := 0; i < 4; i++ {
+		assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	stats := d.Stats()
+	assert.Equal(int64(4), stats.Accepted)
+	assert.Equal(int64(1), stats.Rejected)
+	assert.Len(stats.WorkerQueueDepth, 2)
+	assert.Equal(4, stats.QueueDepth+stats.WorkerQueueDepth[0]+stats.WorkerQueueDepth[1])
+
+	_, _, err = d.Flush(ctx)
+	assert.NoError(err)
+	stats = d.Stats()
+	assert.Equal(int64(proxy.callCount()), stats.Bulks)
+	assert.Equal(int64(2), stats.Succeeded())
+	assert.Equal(int64(2), stats.Failed())
+	assert.Equal(uint64(proxy.callCount()), stats.BulkLatency.Count)
+}
+
 func TestDispatcher_Start(t *testing.T) {
 	assert := assert.New(t)
 
@@ -188,6 +677,178 @@ func TestDispatcher_Stop(t *testing.T) {
 	assert.NoError(err)
 }
 
+func TestDispatcher_WAL(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// actions remained by a crashed process.
+	l := testWAL(t, dir, defaultWALSegmentSize)
+	_, err = l.open()
+	assert.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err := l.append(&mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)})
+		assert.NoError(err)
+	}
+	l.file.Close()
+
+	d, err := NewDispatcher(WithWALOption(dir), WithWorkerWaitInterval(10*time.Millisecond))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = proxy
+	}
+
+	// replayed when started.
+	assert.NoError(d.Start())
+	assert.NoError(d.AddAction(context.Background(), &mockAction{op: ES_INDEX, index: "allan", id: "3"}))
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(d.Stop())
+
+	ids := map[string]bool{}
+	for _, call := range proxy.calls {
+		for _, act := range call {
+			ids[act.GetID()] = true
+		}
+	}
+	assert.Equal(map[string]bool{"0": true, "1": true, "2": true, "3": true}, ids)
+
+	// all of actions are delivered.
+	assert.Len(walSegments(dir), 0)
+}
+
+func TestDispatcher_Shutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(2), WithWorkerWaitInterval(time.Hour), WithWALOption(dir))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = proxy
+	}
+
+	ctx := context.Background()
+	_, err = d.Shutdown(ctx)
+	assert.Error(err)
+
+	// all of actions are delivered.
+	assert.NoError(d.Start())
+	for i := 0; i < 10; i++ {
+		assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	undelivered, err := d.Shutdown(ctx)
+	assert.NoError(err)
+	assert.Len(undelivered, 0)
+	assert.Error(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan"}))
+	assert.Len(walSegments(dir), 0)
+
+	// deadline hits.
+	proxy.delay = time.Hour
+	assert.NoError(d.Start())
+	var results []*ActionResult
+	for i := 0; i < 10; i++ {
+		result, err := d.AddActionWithResult(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)})
+		assert.NoError(err)
+		results = append(results, result)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	undelivered, err = d.Shutdown(timeout)
+	assert.True(time.Since(start) < 5*time.Second)
+	assert.Equal(context.DeadlineExceeded, err)
+	// they remain in a write-ahead log instead of being returned.
+	assert.Len(undelivered, 0)
+	for _, result := range results {
+		_, err := result.Wait(ctx)
+		assert.Error(err)
+	}
+
+	// undelivered actions are replayed.
+	proxy.delay = 0
+	assert.NoError(d.Start())
+	_, err = d.Shutdown(ctx)
+	assert.NoError(err)
+	assert.Len(walSegments(dir), 0)
+	ids := map[string]int{}
+	for _, call := range proxy.calls {
+		for _, act := range call {
+			ids[act.GetID()]++
+		}
+	}
+	assert.Len(ids, 10)
+
+	// undelivered actions are returned without a write-ahead log.
+	d, err = NewDispatcher(WithWorkerSizeOption(2), WithWorkerWaitInterval(time.Hour))
+	assert.NoError(err)
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = &mockProxy{delay: time.Hour, bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+			return mockBulkResponse(acts), nil
+		}}
+	}
+	assert.NoError(d.Start())
+	for i := 0; i < 10; i++ {
+		assert.NoError(d.AddAction(ctx, &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	timeout, cancel = context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	undelivered, err = d.Shutdown(timeout)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Len(undelivered, 10)
+}
+
+func TestDispatcher_ShutdownRace(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDispatcher(WithWorkerSizeOption(2), WithGlobalQueueSizeOption(10), WithWorkerWaitInterval(time.Millisecond))
+	assert.NoError(err)
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	for _, w := range d.(*dispatcher).bk.workers {
+		w.esClient = proxy
+	}
+	assert.NoError(d.Start())
+
+	// every accepted action is delivered or returned even if AddAction races shutdown.
+	var accepted int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				if err := d.AddAction(context.Background(), &mockAction{op: ES_INDEX, index: "allan",
+					id: fmt.Sprintf("%d-%d", i, j)}); err != nil {
+					return
+				}
+				atomic.AddInt64(&accepted, 1)
+			}
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	undelivered, err := d.Shutdown(context.Background())
+	assert.NoError(err)
+	wg.Wait()
+
+	delivered := 0
+	for _, call := range proxy.calls {
+		delivered += len(call)
+	}
+	assert.Equal(int(atomic.LoadInt64(&accepted)), delivered+len(undelivered))
+}
+
 func TestProcessDispatcher(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,86 @@
+package esworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// RawDocAction is an action which has a document already serialized to JSON.
+// GetRawDoc is used instead of GetDoc, and it is written to a bulk body directly.
+type RawDocAction interface {
+	Action
+	GetRawDoc() []byte
+}
+
+// ValueDocAction is an action which has a document as any value such as a struct.
+// GetValue is used instead of GetDoc, and it is encoded to a bulk body directly.
+type ValueDocAction interface {
+	Action
+	GetValue() interface{}
+}
+
+// writeDoc writes a document line of an action on the version to a buffer without intermediate maps if possible.
+func writeDoc(buf *bytes.Buffer, act Action, v ESVersion, codec Codec) error {
+	switch a := act.(type) {
+	case *UpdateAction:
+		return encodeLine(buf, codec, a.body(v))
+	case RawDocAction:
+		raw := a.GetRawDoc()
+		if len(raw) == 0 {
+			return nil
+		}
+		// a document must be a line in a bulk body.
+		if err := json.Compact(buf, raw); err != nil {
+			return fmt.Errorf("[err] writeDoc (invalid raw doc) %w", err)
+		}
+		buf.WriteByte('\n')
+		return nil
+	case ValueDocAction:
+		v := a.GetValue()
+		if v == nil {
+			return nil
+		}
+		return encodeLine(buf, codec, v)
+	}
+
+	doc := act.GetDoc()
+	if len(doc) == 0 {
+		return nil
+	}
+	return encodeLine(buf, codec, doc)
+}
+
+// encodeDoc returns a document of an action on the version as JSON (nil if it has no document).
+func encodeDoc(act Action, v ESVersion, codec Codec) (json.RawMessage, error) {
+	buf := &bytes.Buffer{}
+	if err := writeDoc(buf, act, v, codec); err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// updateKeys reports whether an update body of an action has doc and script keys.
+// checked is false if a body is given as a value, which is not able to be checked without encoding it.
+func updateKeys(act Action) (hasDoc, hasScript, checked bool) {
+	switch a := act.(type) {
+	case RawDocAction:
+		body := map[string]json.RawMessage{}
+		if err := json.Unmarshal(a.GetRawDoc(), &body); err != nil {
+			return false, false, true
+		}
+		_, hasDoc = body["doc"]
+		_, hasScript = body["script"]
+		return hasDoc, hasScript, true
+	case ValueDocAction:
+		return false, false, false
+	}
+
+	body := act.GetDoc()
+	_, hasDoc = body["doc"]
+	_, hasScript = body["script"]
+	return hasDoc, hasScript, true
+}
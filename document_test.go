@@ -0,0 +1,130 @@
+package esworker
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	mockRawAction struct {
+		mockAction
+		raw []byte
+	}
+
+	mockValueAction struct {
+		mockAction
+		value interface{}
+	}
+
+	mockDocument struct {
+		Field1 int    `json:"field1"`
+		Field2 string `json:"field2"`
+	}
+)
+
+func (mockAct *mockRawAction) GetRawDoc() []byte {
+	return mockAct.raw
+}
+
+func (mockAct *mockValueAction) GetValue() interface{} {
+	return mockAct.value
+}
+
+func TestWriteDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input  Action
+		output string
+		isErr  bool
+	}{
+		"map": {input: &mockAction{op: ES_INDEX, doc: map[string]interface{}{"field1": 1}},
+			output: `{"field1":1}` + "\n"},
+		"empty map": {input: &mockAction{op: ES_DELETE}, output: ""},
+		"raw": {input: &mockRawAction{mockAction: mockAction{op: ES_INDEX}, raw: []byte("{\n \"field1\": 1\n}")},
+			output: `{"field1":1}` + "\n"},
+		"empty raw":   {input: &mockRawAction{mockAction: mockAction{op: ES_DELETE}}, output: ""},
+		"invalid raw": {input: &mockRawAction{mockAction: mockAction{op: ES_INDEX}, raw: []byte("{")}, isErr: true},
+		"value": {input: &mockValueAction{mockAction: mockAction{op: ES_INDEX}, value: &mockDocument{Field1: 1, Field2: "a"}},
+			output: `{"field1":1,"field2":"a"}` + "\n"},
+		"nil value": {input: &mockValueAction{mockAction: mockAction{op: ES_DELETE}}, output: ""},
+	}
+
+	for _, t := range tests {
+		buf := &bytes.Buffer{}
+		err := writeDoc(buf, t.input, V7, defaultCodec)
+		assert.Equal(t.isErr, err != nil)
+		if err == nil {
+			assert.Equal(t.output, buf.String())
+		}
+	}
+}
+
+func TestEncodeDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	raw, err := encodeDoc(&mockValueAction{mockAction: mockAction{op: ES_INDEX}, value: &mockDocument{Field1: 1, Field2: "a"}}, V7, defaultCodec)
+	assert.NoError(err)
+	assert.Equal(`{"field1":1,"field2":"a"}`, string(raw))
+
+	raw, err = encodeDoc(&mockAction{op: ES_DELETE}, V7, defaultCodec)
+	assert.NoError(err)
+	assert.Nil(raw)
+}
+
+func TestUpdateKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input                      Action
+		hasDoc, hasScript, checked bool
+	}{
+		"map":       {input: &mockAction{op: ES_UPDATE, doc: map[string]interface{}{"doc": 1}}, hasDoc: true, checked: true},
+		"update":    {input: &UpdateAction{Script: &Script{Source: "ctx._source.a++"}}, hasScript: true, checked: true},
+		"raw":       {input: &RawAction{Doc: []byte(`{"doc": {}, "script": {}}`)}, hasDoc: true, hasScript: true, checked: true},
+		"raw array": {input: &RawAction{Doc: []byte(`[]`)}, checked: true},
+		"value":     {input: &mockValueAction{value: map[string]interface{}{"doc": 1}}, checked: false},
+	}
+
+	for _, t := range tests {
+		hasDoc, hasScript, checked := updateKeys(t.input)
+		assert.Equal(t.hasDoc, hasDoc)
+		assert.Equal(t.hasScript, hasScript)
+		assert.Equal(t.checked, checked)
+	}
+}
+
+func BenchmarkESProxy_MakeReader(b *testing.B) {
+	proxy, err := createESProxy(testCfg(V7))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ep := proxy.(*esproxy)
+
+	doc := &mockDocument{Field1: 200, Field2: "benchmark"}
+	tests := map[string]Action{
+		"map": &mockAction{op: ES_INDEX, index: "allan",
+			doc: map[string]interface{}{"field1": 200, "field2": "benchmark"}},
+		"raw": &mockRawAction{mockAction: mockAction{op: ES_INDEX, index: "allan"},
+			raw: []byte(`{"field1":200,"field2":"benchmark"}`)},
+		"value": &mockValueAction{mockAction: mockAction{op: ES_INDEX, index: "allan"}, value: doc},
+	}
+
+	for name, act := range tests {
+		acts := make([]Action, 100)
+		for i := range acts {
+			acts[i] = act
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf := &bytes.Buffer{}
+				if err := ep.makeReader(buf, acts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,21 +2,12 @@ package esworker
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"strconv"
+	"net/http"
 	"sync"
-
-	es5 "github.com/elastic/go-elasticsearch/v5"
-	es6 "github.com/elastic/go-elasticsearch/v6"
-	es7 "github.com/elastic/go-elasticsearch/v7"
-
-	es5_logger "github.com/elastic/go-elasticsearch/v5/estransport"
-	es6_logger "github.com/elastic/go-elasticsearch/v6/estransport"
-	es7_logger "github.com/elastic/go-elasticsearch/v7/estransport"
 )
 
 // ESOperation is a type of elasticsearch.
@@ -33,9 +24,6 @@ const (
 const (
 	defaultESDocType   = "_doc"
 	defaultESV5DocType = "doc"
-
-	metaFormatA = `{"%s": {"_index": "%s", "_type": "%s"}}%s`
-	metaFormatB = `{"%s": {"_index": "%s", "_type": "%s", "_id": "%s"}}%s`
 )
 
 // GetString converts int to string value.
@@ -54,11 +42,22 @@ func (ao ESOperation) GetString() string {
 	}
 }
 
+// parseESOperation converts string to ESOperation.
+func parseESOperation(s string) (ESOperation, bool) {
+	for _, op := range []ESOperation{ES_INDEX, ES_CREATE, ES_UPDATE, ES_DELETE} {
+		if op.GetString() == s {
+			return op, true
+		}
+	}
+	return ES_INDEX, false
+}
+
 // it is response structs of elasticserach.
 type (
 	ESResponseCause struct {
-		Type   string `json:"type"`
-		Reason string `json:"reason"`
+		Type     string           `json:"type"`
+		Reason   string           `json:"reason"`
+		CausedBy *ESResponseCause `json:"caused_by,omitempty"`
 	}
 
 	ESResponseError struct {
@@ -68,10 +67,15 @@ type (
 	}
 
 	ESResponseStatus struct {
-		Id     string          `json:"_id"`
-		Result string          `json:"result"`
-		Status int             `json:"status"`
-		Error  ESResponseError `json:"error"`
+		Index       string          `json:"_index"`
+		DocType     string          `json:"_type"`
+		Id          string          `json:"_id"`
+		Version     int64           `json:"_version"`
+		Result      string          `json:"result"`
+		SeqNo       int64           `json:"_seq_no"`
+		PrimaryTerm int64           `json:"_primary_term"`
+		Status      int             `json:"status"`
+		Error       ESResponseError `json:"error"`
 	}
 
 	ESResponseItem struct {
@@ -84,9 +88,26 @@ type (
 	ESResponseBulk struct {
 		Errors bool             `json:"errors"`
 		Items  []ESResponseItem `json:"items"`
+		bytes  int              // bytes of a request body.
 	}
 )
 
+// result returns an operation and a status of the item.
+func (item *ESResponseItem) result() (ESOperation, *ESResponseStatus) {
+	switch {
+	case item.Index.Status != 0:
+		return ES_INDEX, &item.Index
+	case item.Create.Status != 0:
+		return ES_CREATE, &item.Create
+	case item.Update.Status != 0:
+		return ES_UPDATE, &item.Update
+	case item.Delete.Status != 0:
+		return ES_DELETE, &item.Delete
+	default:
+		return ES_INDEX, nil
+	}
+}
+
 // Count returns a success and fail count.
 func (bulk *ESResponseBulk) Count() (success int, fail int) {
 	for _, item := range bulk.Items {
@@ -120,190 +141,132 @@ func (bulk *ESResponseBulk) Count() (success int, fail int) {
 	return
 }
 
-// ResultErrors returns bulk result error.
+// rejected returns whether any item is rejected with 429 because a cluster is overloaded.
+func (bulk *ESResponseBulk) rejected() bool {
+	if !bulk.Errors {
+		return false
+	}
+	for i := range bulk.Items {
+		if _, status := bulk.Items[i].result(); status != nil && status.Status == http.StatusTooManyRequests {
+			return true
+		}
+	}
+	return false
+}
+
+// ResultError returns a BulkError holding failed items, or nil if every item succeeded.
 func (bulk *ESResponseBulk) ResultError() error {
-	errMap := make(map[string][]map[string]string)
-	for _, item := range bulk.Items {
-		switch {
-		case item.Index.Status != 0:
-			if item.Index.Status > 299 {
-				err := map[string]string{}
-				err["id"] = item.Index.Id
-				err["status"] = strconv.Itoa(item.Index.Status)
-				err["type"] = item.Index.Error.Type
-				err["reason"] = item.Index.Error.Reason
-				err["caused_by_type"] = item.Index.Error.Cause.Type
-				err["caused_by_reason"] = item.Index.Error.Cause.Reason
-				errMap["index"] = append(errMap["index"], err)
-			}
-		case item.Create.Status != 0:
-			if item.Create.Status > 299 {
-				err := map[string]string{}
-				err["id"] = item.Create.Id
-				err["status"] = strconv.Itoa(item.Create.Status)
-				err["type"] = item.Create.Error.Type
-				err["reason"] = item.Create.Error.Reason
-				err["caused_by_type"] = item.Create.Error.Cause.Type
-				err["caused_by_reason"] = item.Create.Error.Cause.Reason
-				errMap["create"] = append(errMap["index"], err)
-			}
-		case item.Update.Status != 0:
-			if item.Update.Status > 299 {
-				err := map[string]string{}
-				err["id"] = item.Update.Id
-				err["status"] = strconv.Itoa(item.Update.Status)
-				err["type"] = item.Update.Error.Type
-				err["reason"] = item.Update.Error.Reason
-				err["caused_by_type"] = item.Update.Error.Cause.Type
-				err["caused_by_reason"] = item.Update.Error.Cause.Reason
-				errMap["update"] = append(errMap["index"], err)
-			}
-		case item.Delete.Status != 0:
-			if item.Delete.Status > 299 {
-				err := map[string]string{}
-				err["id"] = item.Delete.Id
-				err["status"] = strconv.Itoa(item.Delete.Status)
-				err["type"] = item.Delete.Error.Type
-				err["reason"] = item.Delete.Error.Reason
-				err["caused_by_type"] = item.Delete.Error.Cause.Type
-				err["caused_by_reason"] = item.Delete.Error.Cause.Reason
-				errMap["delete"] = append(errMap["index"], err)
-			}
+	bulkErr := &BulkError{}
+	for i := range bulk.Items {
+		op, status := bulk.Items[i].result()
+		if status != nil && status.Status > 299 {
+			bulkErr.Items = append(bulkErr.Items, newBulkItemError(op, status, nil))
 		}
 	}
-	if len(errMap) == 0 {
+	if len(bulkErr.Items) == 0 {
 		return nil
-	} else {
-		msg := ""
-		if data, err := json.Marshal(errMap); err != nil {
-			msg = fmt.Sprintf("%v", errMap)
-		} else {
-			msg = fmt.Sprintf("%s", string(data))
-		}
-
-		return fmt.Errorf("[err][go-esworker-process][bulk] %s", msg)
 	}
+	return bulkErr
 }
 
 // ESProxy is an interface that actually request the elasticserach.
 type ESProxy interface {
 	Bulk(ctx context.Context, acts []Action) (bulk *ESResponseBulk, err error)
+	Encode(act Action) ([]byte, error)
+}
+
+// encodedAction is an action whose lines in a bulk body are serialized in advance, and they are written as they are.
+type encodedAction struct {
+	Action
+	lines []byte
 }
 
 type esproxy struct {
-	sync.RWMutex
-	version   ESVersion
-	es5Config es5.Config
-	es6Config es6.Config
-	es7Config es7.Config
-	es5Client *es5.Client
-	es6Client *es6.Client
-	es7Client *es7.Client
-	bufPool   *sync.Pool
+	version     ESVersion
+	backend     Backend
+	codec       Codec
+	filterPath  string // filter_path of a bulk response (every field if empty).
+	bufPool     *sync.Pool
+	gzipPool    *sync.Pool // pool of gzip writers (nil if compression is disabled).
+	gzipMinSize int        // a body smaller than it is not compressed.
 }
 
 // Bulk is to request a bulk action to the elasticsearch.
-func (ep *esproxy) Bulk(ctx context.Context, acts []Action) (bulk *ESResponseBulk, err error) {
-	result := &ESResponseBulk{}
+func (ep *esproxy) Bulk(ctx context.Context, acts []Action) (*ESResponseBulk, error) {
 	if len(acts) == 0 {
-		return
+		return &ESResponseBulk{}, nil
 	}
 
-	buf, suberr := ep.makeReader(acts)
-	if suberr != nil {
-		err = suberr
-		return
-	}
+	// get buffer from sync.pool, and it is kept until the request is finished.
+	buf := ep.bufPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		ep.bufPool.Put(buf)
+	}()
 
-	// response body
-	var body io.ReadCloser
-	statusErr := false
+	if err := ep.makeReader(buf, acts); err != nil {
+		return nil, err
+	}
 
-	// execute a bulk operation depending on ES version.
-	switch ep.version {
-	case V5: // elasticsearch v5 could possibly have multiple _type in an index. (default: doc)
-		client, suberr := ep.getES5()
-		if suberr != nil {
-			err = suberr
-			return
-		}
-		resp, suberr := client.Bulk(
-			bytes.NewReader(buf),
-			client.Bulk.WithContext(ctx),
-		)
-		if suberr != nil {
-			err = suberr
-			return
-		}
-		if resp.IsError() {
-			statusErr = true
-		}
-		body = resp.Body
-	case V6: // elasticsearch v6 must have only one the _type in an index. (default: _doc)
-		client, suberr := ep.getES6()
-		if suberr != nil {
-			err = suberr
-			return
-		}
-		resp, suberr := client.Bulk(
-			bytes.NewReader(buf),
-			client.Bulk.WithContext(ctx),
-		)
-		if suberr != nil {
-			err = suberr
-			return
-		}
-		if resp.IsError() {
-			statusErr = true
-		}
-		body = resp.Body
-	case V7: // elasticsearch v7 must have only one the _type in an index. (default: _doc)
-		client, suberr := ep.getES7()
-		if suberr != nil {
-			err = suberr
-			return
-		}
-		resp, suberr := client.Bulk(
-			bytes.NewReader(buf),
-			client.Bulk.WithContext(ctx),
-		)
-		if suberr != nil {
-			err = suberr
-			return
-		}
-		if resp.IsError() {
-			statusErr = true
+	req := &BulkRequest{Body: buf.Bytes(), Actions: len(acts)}
+	if ep.filterPath != "" {
+		req.Params = map[string]string{"filter_path": ep.filterPath}
+	}
+	if ep.gzipPool != nil && buf.Len() >= ep.gzipMinSize {
+		zbuf := ep.bufPool.Get().(*bytes.Buffer)
+		defer func() {
+			zbuf.Reset()
+			ep.bufPool.Put(zbuf)
+		}()
+		if err := ep.compress(zbuf, buf.Bytes()); err != nil {
+			return nil, err
 		}
-		body = resp.Body
-	default:
-		err = fmt.Errorf("[err] Bulk (invalid version)")
-		return
+		req.Body = zbuf.Bytes()
+		req.Header = map[string]string{"Content-Encoding": "gzip"}
 	}
 
-	if body != nil {
-		defer body.Close()
+	result, err := ep.backend.Bulk(ctx, req)
+	if err != nil {
+		return nil, err
 	}
+	result.bytes = buf.Len()
+	return result, nil
+}
 
-	// status on response is less than 200 or more than 299.
-	if statusErr {
-		msg, _ := ioutil.ReadAll(body)
-		err = fmt.Errorf("[err] Bulk %s\n", msg)
-		return
-	} else { // parse response body
-		if suberr := json.NewDecoder(body).Decode(result); suberr != nil {
-			err = suberr
-			return
-		}
-	}
+// compress writes a body compressed by gzip to a buffer.
+func (ep *esproxy) compress(dst *bytes.Buffer, body []byte) error {
+	zw := ep.gzipPool.Get().(*gzip.Writer)
+	defer ep.gzipPool.Put(zw)
 
-	bulk = result
-	return
+	zw.Reset(dst)
+	if _, err := zw.Write(body); err != nil {
+		return err
+	}
+	return zw.Close()
 }
 
-// makeReader makes reader on bytes package.
-func (ep *esproxy) makeReader(acts []Action) ([]byte, error) {
+// makeReader writes a bulk body of actions to a buffer.
+func (ep *esproxy) makeReader(buf *bytes.Buffer, acts []Action) error {
 	if len(acts) == 0 {
-		return nil, fmt.Errorf("[err] makeReader (empty params)")
+		return fmt.Errorf("[err] makeReader (empty params)")
+	}
+
+	for _, act := range acts {
+		if ea, ok := act.(*encodedAction); ok {
+			buf.Write(ea.lines)
+			continue
+		}
+		if err := ep.writeAction(buf, act); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode returns lines of an action serialized in a bulk body.
+func (ep *esproxy) Encode(act Action) ([]byte, error) {
+	if act == nil {
+		return nil, fmt.Errorf("[err] Encode (empty params)")
 	}
 
 	// get buffer from sync.pool
@@ -313,122 +276,45 @@ func (ep *esproxy) makeReader(acts []Action) ([]byte, error) {
 		ep.bufPool.Put(buf)
 	}()
 
+	if err := ep.writeAction(buf, act); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// writeAction writes lines of an action to a buffer.
+func (ep *esproxy) writeAction(buf *bytes.Buffer, act Action) error {
 	// extract default _type(table)
 	defaultType := defaultESDocType
 	if ep.version == V5 {
 		defaultType = defaultESV5DocType
 	}
 
-	for _, act := range acts {
-		// buffer
-		var meta []byte
-		if act.GetDocType() != "" && act.GetID() != "" {
-			meta = []byte(fmt.Sprintf(metaFormatB,
-				act.GetOperation().GetString(),
-				act.GetIndex(),
-				act.GetDocType(),
-				act.GetID(),
-				"\n",
-			))
-		} else if act.GetDocType() != "" {
-			meta = []byte(fmt.Sprintf(metaFormatA,
-				act.GetOperation().GetString(),
-				act.GetIndex(),
-				act.GetDocType(),
-				"\n",
-			))
-		} else if act.GetID() != "" {
-			meta = []byte(fmt.Sprintf(metaFormatB,
-				act.GetOperation().GetString(),
-				act.GetIndex(),
-				defaultType,
-				act.GetID(),
-				"\n",
-			))
-		} else {
-			meta = []byte(fmt.Sprintf(metaFormatA,
-				act.GetOperation().GetString(),
-				act.GetIndex(),
-				defaultType,
-				"\n",
-			))
-		}
-
-		if len(act.GetDoc()) == 0 {
-			buf.Write(meta)
-		} else {
-			doc, err := json.Marshal(act.GetDoc())
-			if err != nil {
-				return nil, err
-			}
-			doc = append(doc, "\n"...)
-
-			buf.Grow(len(meta) + len(doc))
-			buf.Write(meta)
-			buf.Write(doc)
-		}
+	docType := act.GetDocType()
+	if docType == "" {
+		docType = defaultType
 	}
-	return buf.Bytes(), nil
-}
-
-// getES5 is to get a client of es5.
-func (ep *esproxy) getES5() (*es5.Client, error) {
-	if ep.es5Client == nil {
-		// lock read and write.
-		ep.Lock()
-		defer ep.Unlock()
-
-		// check once more
-		if ep.es5Client != nil {
-			return ep.es5Client, nil
-		}
-		client, err := es5.NewClient(ep.es5Config)
-		if err != nil {
-			return nil, err
-		}
-		ep.es5Client = client
+	// _type is removed since v8.
+	if ep.version.typeless() {
+		docType = ""
 	}
-	return ep.es5Client, nil
-}
 
-// getES6 is to get a client of es6.
-func (ep *esproxy) getES6() (*es6.Client, error) {
-	if ep.es6Client == nil {
-		// lock read and write.
-		ep.Lock()
-		defer ep.Unlock()
-
-		// check once more
-		if ep.es6Client != nil {
-			return ep.es6Client, nil
-		}
-		client, err := es6.NewClient(ep.es6Config)
-		if err != nil {
-			return nil, err
-		}
-		ep.es6Client = client
+	meta := getActionMeta(act)
+	if err := meta.validate(ep.version, act.GetOperation()); err != nil {
+		return err
 	}
-	return ep.es6Client, nil
-}
 
-// getES7 is to get a client of es7.
-func (ep *esproxy) getES7() (*es7.Client, error) {
-	if ep.es7Client == nil {
-		// lock read and write.
-		ep.Lock()
-		defer ep.Unlock()
-
-		// check once more
-		if ep.es7Client != nil {
-			return ep.es7Client, nil
-		}
-		client, err := es7.NewClient(ep.es7Config)
-		if err != nil {
-			return nil, err
-		}
-		ep.es7Client = client
+	start := buf.Len()
+	if err := encodeLine(buf, ep.codec, map[string]interface{}{
+		act.GetOperation().GetString(): newBulkMeta(ep.version, act.GetIndex(), docType, act.GetID(), meta),
+	}); err != nil {
+		return err
+	}
+	if err := writeDoc(buf, act, ep.version, ep.codec); err != nil {
+		buf.Truncate(start)
+		return err
 	}
-	return ep.es7Client, nil
+	return nil
 }
 
 // createESProxy is to create ESProxy interface.
@@ -437,64 +323,35 @@ func createESProxy(cfg *config) (ESProxy, error) {
 		return nil, fmt.Errorf("[err] createESProxy empty params")
 	}
 
-	// es5 config
-	es5conf := es5.Config{
-		Addresses: cfg.addrs,
-		Username:  cfg.username,
-		Password:  cfg.password,
-		Transport: cfg.transport,
-	}
-	if cfg.logger != nil {
-		logger, err := cfg.logger.GetESLogger(V5)
-		if err != nil {
-			return nil, err
-		}
-		es5conf.Logger = logger.(es5_logger.Logger)
+	backend, err := createBackend(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	// es6 config
-	es6conf := es6.Config{
-		Addresses: cfg.addrs,
-		Username:  cfg.username,
-		Password:  cfg.password,
-		Transport: cfg.transport,
-		CloudID:   cfg.cloudId,
-		APIKey:    cfg.apiKey,
-	}
-	if cfg.logger != nil {
-		logger, err := cfg.logger.GetESLogger(V6)
-		if err != nil {
-			return nil, err
-		}
-		es6conf.Logger = logger.(es6_logger.Logger)
-	}
-
-	// es7 config
-	es7conf := es7.Config{
-		Addresses: cfg.addrs,
-		Username:  cfg.username,
-		Password:  cfg.password,
-		Transport: cfg.transport,
-		CloudID:   cfg.cloudId,
-		APIKey:    cfg.apiKey,
-	}
-	if cfg.logger != nil {
-		logger, err := cfg.logger.GetESLogger(V7)
-		if err != nil {
-			return nil, err
-		}
-		es7conf.Logger = logger.(es7_logger.Logger)
-	}
-
-	return &esproxy{
-		version:   cfg.version,
-		es5Config: es5conf,
-		es6Config: es6conf,
-		es7Config: es7conf,
+	ep := &esproxy{
+		version:    cfg.version,
+		backend:    backend,
+		codec:      getCodec(cfg.codec),
+		filterPath: cfg.bulkFilterPath,
 		bufPool: &sync.Pool{
 			New: func() interface{} {
 				return &bytes.Buffer{}
 			},
 		},
-	}, nil
+	}
+
+	if cfg.gzip {
+		level := cfg.gzipLevel
+		if _, err := gzip.NewWriterLevel(ioutil.Discard, level); err != nil {
+			return nil, fmt.Errorf("[err] createESProxy (invalid gzip level %d)", level)
+		}
+		ep.gzipMinSize = cfg.gzipMinSize
+		ep.gzipPool = &sync.Pool{
+			New: func() interface{} {
+				zw, _ := gzip.NewWriterLevel(ioutil.Discard, level)
+				return zw
+			},
+		}
+	}
+	return ep, nil
 }
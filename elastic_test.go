@@ -1,9 +1,14 @@
 package esworker
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -122,6 +127,19 @@ func TestESResponseBulk_ResultErrors(t *testing.T) {
 		assert.Equal(t.isErr, err != nil)
 		fmt.Println(err)
 	}
+
+	// failed items are kept with their operations.
+	err := tests["exist"].input.ResultError()
+	bulkErr := &BulkError{}
+	assert.True(errors.As(err, &bulkErr))
+	assert.Len(bulkErr.Items, 5)
+	ops := map[ESOperation]int{}
+	for _, item := range bulkErr.Items {
+		ops[item.Operation]++
+	}
+	assert.Equal(map[ESOperation]int{ES_INDEX: 2, ES_UPDATE: 2, ES_CREATE: 1}, ops)
+	assert.Equal("type2", bulkErr.Items[0].CausedBy.Type)
+	assert.Nil(bulkErr.Items[1].CausedBy)
 }
 
 func TestESOperation_GetString(t *testing.T) {
@@ -146,25 +164,161 @@ func TestESOperation_GetString(t *testing.T) {
 func TestEsproxy(t *testing.T) {
 	assert := assert.New(t)
 
-	cfg := testCfg(V6)
 	// make proxy on ES
 	_, err := createESProxy(nil)
 	assert.Error(err)
 
+	for _, v := range []ESVersion{V5, V6, V7, V8, OpenSearch} {
+		proxy, err := createESProxy(testCfg(v))
+		assert.NoError(err)
+		assert.NotNil(proxy.(*esproxy).backend)
+	}
+
+	_, err = createESProxy(testCfg(ESVersion(-1)))
+	assert.Error(err)
+
+	// a backend from an option is used.
+	backend := &mockBackend{}
+	cfg := testCfg(V7)
+	WithBackend(backend).apply(cfg)
 	proxy, err := createESProxy(cfg)
 	assert.NoError(err)
+	assert.Equal(backend, proxy.(*esproxy).backend)
 
-	// get client on ES
-	s := proxy.(*esproxy)
+	resp, err := proxy.Bulk(context.Background(), []Action{&mockAction{op: ES_DELETE, index: "allan", id: "1"}})
+	assert.NoError(err)
+	assert.Equal(1, len(resp.Items))
+	assert.Equal(resp.bytes, len(backend.bodies[0]))
+	assert.Nil(backend.params[0])
 
-	_, err = s.getES5()
+	// filter_path is requested if it is given.
+	WithBulkFilterPathOption(defaultBulkFilterPath).apply(cfg)
+	proxy, err = createESProxy(cfg)
+	assert.NoError(err)
+	_, err = proxy.Bulk(context.Background(), []Action{&mockAction{op: ES_DELETE, index: "allan", id: "1"}})
 	assert.NoError(err)
+	assert.Equal(map[string]string{"filter_path": defaultBulkFilterPath}, backend.params[1])
+}
 
-	_, err = s.getES6()
+func TestESProxy_Encode(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy, err := createESProxy(testCfg(V6))
 	assert.NoError(err)
 
-	_, err = s.getES7()
+	_, err = proxy.Encode(nil)
+	assert.Error(err)
+
+	acts := []Action{
+		&mockAction{op: ES_INDEX, index: "allan", id: "1", doc: map[string]interface{}{"field": "value"}},
+		&mockAction{op: ES_DELETE, index: "allan", id: "1"},
+	}
+
+	var lines [][]byte
+	for _, act := range acts {
+		line, err := proxy.Encode(act)
+		assert.NoError(err)
+		lines = append(lines, line)
+	}
+
+	body := &bytes.Buffer{}
+	assert.NoError(proxy.(*esproxy).makeReader(body, acts))
+	assert.Equal(string(lines[0])+string(lines[1]), body.String())
+
+	// lines serialized in advance are written as they are.
+	body.Reset()
+	assert.NoError(proxy.(*esproxy).makeReader(body, []Action{
+		&encodedAction{Action: acts[0], lines: lines[0]},
+		&encodedAction{Action: acts[1], lines: []byte("{}\n")},
+	}))
+	assert.Equal(string(lines[0])+"{}\n", body.String())
+}
+
+func TestESProxy_Gzip(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := testCfg(V7)
+	WithGzipOption(100, 0).apply(cfg)
+	_, err := createESProxy(cfg)
+	assert.Error(err)
+
+	backend := &mockBackend{}
+	cfg = testCfg(V7)
+	WithBackend(backend).apply(cfg)
+	WithGzipOption(gzip.BestSpeed, 100).apply(cfg)
+	proxy, err := createESProxy(cfg)
+	assert.NoError(err)
+
+	small := []Action{&mockAction{op: ES_DELETE, index: "allan", id: "1"}}
+	large := []Action{&mockAction{op: ES_INDEX, index: "allan", id: "1",
+		doc: map[string]interface{}{"field": strings.Repeat("value", 100)}}}
+
+	// a body smaller than a threshold is not compressed.
+	resp, err := proxy.Bulk(context.Background(), small)
 	assert.NoError(err)
+	assert.Equal(1, len(resp.Items))
+	assert.Nil(backend.headers[0])
+	assert.Equal(resp.bytes, len(backend.bodies[0]))
+
+	for i := 0; i < 2; i++ {
+		resp, err = proxy.Bulk(context.Background(), large)
+		assert.NoError(err)
+		assert.Equal(1, len(resp.Items))
+		assert.Equal("gzip", backend.headers[i+1]["Content-Encoding"])
+		assert.True(len(backend.bodies[i+1]) < resp.bytes)
+
+		zr, err := gzip.NewReader(bytes.NewReader(backend.bodies[i+1]))
+		assert.NoError(err)
+		body, err := ioutil.ReadAll(zr)
+		assert.NoError(err)
+		line, err := proxy.Encode(large[0])
+		assert.NoError(err)
+		assert.Equal(string(line), string(body))
+	}
+}
+
+func TestESProxy_MakeReader(t *testing.T) {
+	assert := assert.New(t)
+
+	act := &UpdateAction{
+		Index:           "allan",
+		Id:              "1",
+		Script:          &Script{Source: "ctx._source.a++"},
+		Upsert:          map[string]interface{}{"a": 1},
+		RetryOnConflict: 3,
+	}
+
+	tests := map[string]struct {
+		version ESVersion
+		input   []Action
+		output  string
+	}{
+		"v5 update": {version: V5, input: []Action{act},
+			output: `{"update":{"_index":"allan","_type":"doc","_id":"1","_retry_on_conflict":3}}` + "\n" +
+				`{"script":{"inline":"ctx._source.a++"},"upsert":{"a":1}}` + "\n"},
+		"v7 update": {version: V7, input: []Action{act},
+			output: `{"update":{"_index":"allan","_type":"_doc","_id":"1","retry_on_conflict":3}}` + "\n" +
+				`{"script":{"source":"ctx._source.a++"},"upsert":{"a":1}}` + "\n"},
+		"v8 without type": {version: V8, input: []Action{
+			&mockAction{op: ES_INDEX, index: "allan", docType: "custom", id: "1", doc: map[string]interface{}{"a": 1}},
+		}, output: `{"index":{"_index":"allan","_id":"1"}}` + "\n" + `{"a":1}` + "\n"},
+		"opensearch without type": {version: OpenSearch, input: []Action{
+			&mockAction{op: ES_DELETE, index: "allan", id: "2"},
+		}, output: `{"delete":{"_index":"allan","_id":"2"}}` + "\n"},
+		"v6 index and delete": {version: V6, input: []Action{
+			&mockAction{op: ES_INDEX, index: "allan", docType: "custom", doc: map[string]interface{}{"a": 1}},
+			&mockAction{op: ES_DELETE, index: "allan", id: "2"},
+		}, output: `{"index":{"_index":"allan","_type":"custom"}}` + "\n" + `{"a":1}` + "\n" +
+			`{"delete":{"_index":"allan","_type":"_doc","_id":"2"}}` + "\n"},
+	}
+
+	for _, t := range tests {
+		proxy, err := createESProxy(testCfg(t.version))
+		assert.NoError(err)
+		body := &bytes.Buffer{}
+		assert.NoError(proxy.(*esproxy).makeReader(body, t.input))
+		assert.Equal(t.output, body.String())
+	}
 }
 
 func TestESProxy_Bulk(t *testing.T) {
@@ -226,6 +380,14 @@ func TestESProxy_Bulk(t *testing.T) {
 			docType: "mycustom",
 			id:      fmt.Sprintf("%d", now),
 		},
+		&UpdateAction{
+			Index:           "allan",
+			DocType:         "mycustom",
+			Id:              fmt.Sprintf("%d", now+3),
+			Script:          &Script{Source: "ctx._source.field1 += params.n", Params: map[string]interface{}{"n": 1}},
+			Upsert:          map[string]interface{}{"field1": 600},
+			RetryOnConflict: 3,
+		},
 	}
 
 	// doc default
@@ -306,7 +468,7 @@ func TestESProxy_Bulk(t *testing.T) {
 	result, err := proxy.Bulk(ctx, acts)
 	assert.NoError(err)
 	success, fail := result.Count()
-	assert.Equal(5, success)
+	assert.Equal(6, success)
 	assert.Equal(0, fail)
 
 	result, err = proxy.Bulk(ctx, acts2)
@@ -348,7 +510,7 @@ func TestESProxy_Bulk(t *testing.T) {
 	result, err = proxy.Bulk(ctx, acts)
 	assert.NoError(err)
 	success, fail = result.Count()
-	assert.Equal(5, success)
+	assert.Equal(6, success)
 	assert.Equal(0, fail)
 
 	// not insert
@@ -392,7 +554,7 @@ func TestESProxy_Bulk(t *testing.T) {
 	result, err = proxy.Bulk(ctx, acts)
 	assert.NoError(err)
 	success, fail = result.Count()
-	assert.Equal(5, success)
+	assert.Equal(6, success)
 	assert.Equal(0, fail)
 
 	// not insert
@@ -410,4 +572,51 @@ func TestESProxy_Bulk(t *testing.T) {
 	assert.Equal(1, success)
 	assert.Equal(0, fail)
 	es7Mock.Terminate(ctx)
+
+	// mock es8 and opensearch which have no _type.
+	typeless := map[ESVersion]testcontainers.ContainerRequest{
+		V8: {
+			Image:        "elasticsearch:8.4.3",
+			Name:         "es8-mock",
+			Env:          map[string]string{"discovery.type": "single-node", "xpack.security.enabled": "false"},
+			ExposedPorts: []string{"9200:9200/tcp", "9300:9300/tcp"},
+			WaitingFor:   wait.ForLog("started"),
+		},
+		OpenSearch: {
+			Image:        "opensearchproject/opensearch:2.3.0",
+			Name:         "opensearch-mock",
+			Env:          map[string]string{"discovery.type": "single-node", "plugins.security.disabled": "true"},
+			ExposedPorts: []string{"9200:9200/tcp", "9300:9300/tcp"},
+			WaitingFor:   wait.ForLog("started"),
+		},
+	}
+	for v, req := range typeless {
+		mock, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+			ContainerRequest: req,
+			Started:          true,
+		})
+		assert.NoError(err)
+
+		proxy, err = createESProxy(testCfg(v))
+		assert.NoError(err)
+
+		_, err = proxy.Bulk(ctx, errActs1)
+		assert.Error(err)
+		_, err = proxy.Bulk(ctx, errActs2)
+		assert.Error(err)
+
+		result, err = proxy.Bulk(ctx, acts)
+		assert.NoError(err)
+		success, fail = result.Count()
+		assert.Equal(6, success)
+		assert.Equal(0, fail)
+
+		// a doc type is ignored.
+		result, err = proxy.Bulk(ctx, acts2)
+		assert.NoError(err)
+		success, fail = result.Count()
+		assert.Equal(1, success)
+		assert.Equal(0, fail)
+		mock.Terminate(ctx)
+	}
 }
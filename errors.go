@@ -0,0 +1,109 @@
+package esworker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrQueueFull is returned when an action could not be added because a global queue is full.
+var ErrQueueFull = errors.New("[err] queue full")
+
+// BulkItemError is an error of an item failed in a bulk request.
+type BulkItemError struct {
+	Operation ESOperation      // operation of the item.
+	Index     string           // index of the item.
+	ID        string           // document id of the item.
+	Status    int              // http status of the item.
+	Type      string           // error type of the item.
+	Reason    string           // error reason of the item.
+	CausedBy  *ESResponseCause // a chain of causes (nil if it is empty).
+	Action    Action           // original action (nil if it is not able to be matched).
+}
+
+// BulkError is an error holding every failed item in a bulk request.
+type BulkError struct {
+	Items []*BulkItemError
+}
+
+// BulkRequestError is an error of a bulk request which is failed as a whole with a http status.
+type BulkRequestError struct {
+	Status int    // http status of the response.
+	Body   string // body of the response.
+}
+
+// Error returns a message of the item error including its causes.
+func (e *BulkItemError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[err][go-esworker-process][%s] index %s, id %s, status %d, type %s, reason %s",
+		e.Operation.GetString(), e.Index, e.ID, e.Status, e.Type, e.Reason)
+	for cause := e.CausedBy; cause != nil; cause = cause.CausedBy {
+		fmt.Fprintf(&b, ", caused_by %s %s", cause.Type, cause.Reason)
+	}
+	return b.String()
+}
+
+// Error returns a message joining every item error.
+func (e *BulkError) Error() string {
+	msgs := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		msgs = append(msgs, item.Error())
+	}
+	return fmt.Sprintf("[err][go-esworker-process][bulk] %d items failed: %s", len(e.Items), strings.Join(msgs, "; "))
+}
+
+// AddActionsError is an error of actions rejected by AddActions.
+type AddActionsError struct {
+	First  int     // index of the first rejected action.
+	Errors []error // errors by index of actions (nil if an action is not rejected by itself).
+}
+
+// Error returns a message including a count of rejected actions and the first error.
+func (e *AddActionsError) Error() string {
+	count := 0
+	for _, err := range e.Errors {
+		if err != nil {
+			count++
+		}
+	}
+	return fmt.Sprintf("[err] AddActions %d actions rejected, first %d %v", count, e.First, e.Errors[e.First])
+}
+
+// reject records an error of an action at index i.
+func (e *AddActionsError) reject(i int, err error) {
+	e.Errors[i] = err
+	if e.First < 0 || i < e.First {
+		e.First = i
+	}
+}
+
+// Error returns a message including the status and the body.
+func (e *BulkRequestError) Error() string {
+	return fmt.Sprintf("[err] Bulk status %d %s", e.Status, e.Body)
+}
+
+// newBulkItemError makes an error from a failed item status.
+func newBulkItemError(op ESOperation, status *ESResponseStatus, act Action) *BulkItemError {
+	e := &BulkItemError{
+		Operation: op,
+		Index:     status.Index,
+		ID:        status.Id,
+		Status:    status.Status,
+		Type:      status.Error.Type,
+		Reason:    status.Error.Reason,
+		Action:    act,
+	}
+	if act != nil {
+		if e.Index == "" {
+			e.Index = act.GetIndex()
+		}
+		if e.ID == "" {
+			e.ID = act.GetID()
+		}
+	}
+	if status.Error.Cause.Type != "" || status.Error.Cause.Reason != "" {
+		cause := status.Error.Cause
+		e.CausedBy = &cause
+	}
+	return e
+}
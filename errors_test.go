@@ -0,0 +1,74 @@
+package esworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkItemError_Error(t *testing.T) {
+	assert := assert.New(t)
+
+	status := &ESResponseStatus{}
+	assert.NoError(json.Unmarshal([]byte(`{"_index": "allan", "_id": "1", "status": 400,
+		"error": {"type": "mapper_parsing_exception", "reason": "failed to parse",
+			"caused_by": {"type": "json_parse_exception", "reason": "unexpected character",
+				"caused_by": {"type": "io_exception", "reason": "broken"}}}}`), status))
+
+	act := &mockAction{op: ES_INDEX, index: "allan", id: "1"}
+	err := newBulkItemError(ES_INDEX, status, act)
+	assert.Equal("allan", err.Index)
+	assert.Equal("1", err.ID)
+	assert.Equal(400, err.Status)
+	assert.Equal("mapper_parsing_exception", err.Type)
+	assert.Equal(act, err.Action)
+	assert.Equal("json_parse_exception", err.CausedBy.Type)
+	assert.Equal("io_exception", err.CausedBy.CausedBy.Type)
+	assert.Equal("[err][go-esworker-process][index] index allan, id 1, status 400, type mapper_parsing_exception, reason failed to parse"+
+		", caused_by json_parse_exception unexpected character, caused_by io_exception broken", err.Error())
+
+	// an index and id are filled from the action.
+	err = newBulkItemError(ES_CREATE, &ESResponseStatus{Status: 409}, act)
+	assert.Equal("allan", err.Index)
+	assert.Equal("1", err.ID)
+	assert.Nil(err.CausedBy)
+}
+
+func TestBulkError_As(t *testing.T) {
+	assert := assert.New(t)
+
+	bulkErr := &BulkError{Items: []*BulkItemError{
+		{Operation: ES_CREATE, ID: "1", Status: 409},
+		{Operation: ES_DELETE, ID: "2", Status: 404},
+	}}
+	wrapped := fmt.Errorf("wrapped %w", bulkErr)
+
+	target := &BulkError{}
+	assert.True(errors.As(wrapped, &target))
+	assert.Len(target.Items, 2)
+	assert.Contains(target.Error(), "2 items failed")
+	assert.Contains(target.Error(), "[create]")
+	assert.Contains(target.Error(), "[delete]")
+
+	itemErr := &BulkItemError{}
+	assert.True(errors.As(fmt.Errorf("wrapped %w", bulkErr.Items[0]), &itemErr))
+	assert.Equal(409, itemErr.Status)
+}
+
+func TestAddActionsError(t *testing.T) {
+	assert := assert.New(t)
+
+	e := &AddActionsError{First: -1, Errors: make([]error, 4)}
+	e.reject(2, fmt.Errorf("second"))
+	e.reject(1, fmt.Errorf("first"))
+	assert.Equal(1, e.First)
+	assert.Nil(e.Errors[0])
+	assert.Equal("[err] AddActions 2 actions rejected, first 1 first", e.Error())
+
+	target := &AddActionsError{}
+	assert.True(errors.As(fmt.Errorf("wrapped %w", e), &target))
+	assert.Equal(1, target.First)
+}
@@ -0,0 +1,170 @@
+// Package esprom exposes stats of go-esworker dispatchers as prometheus metrics.
+package esprom
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gjbae1212/go-esworker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "esworker"
+)
+
+var (
+	descAccepted = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "actions_accepted_total"),
+		"Count of actions accepted by AddAction.",
+		[]string{"dispatcher"}, nil,
+	)
+	descRejected = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "actions_rejected_total"),
+		"Count of actions rejected by AddAction.",
+		[]string{"dispatcher"}, nil,
+	)
+	descQueueDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "queue_depth"),
+		"Count of actions waiting in a global queue.",
+		[]string{"dispatcher"}, nil,
+	)
+	descSpillDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "spill_depth"),
+		"Count of actions waiting in spill files.",
+		[]string{"dispatcher"}, nil,
+	)
+	descWorkerQueueDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "worker", "queue_depth"),
+		"Count of actions waiting in a worker queue.",
+		[]string{"dispatcher", "worker"}, nil,
+	)
+	descBulks = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "bulks_total"),
+		"Count of bulk requests sent.",
+		[]string{"dispatcher"}, nil,
+	)
+	descBulkFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "bulk_failures_total"),
+		"Count of bulk requests failed as a whole.",
+		[]string{"dispatcher"}, nil,
+	)
+	descBytesSent = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sent_bytes_total"),
+		"Bytes of bulk bodies sent.",
+		[]string{"dispatcher"}, nil,
+	)
+	descRetries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "retries_total"),
+		"Count of items sent again.",
+		[]string{"dispatcher"}, nil,
+	)
+	descCoalesced = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "actions_coalesced_total"),
+		"Count of actions folded into a queued action on the same document.",
+		[]string{"dispatcher"}, nil,
+	)
+	descDropped = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "actions_dropped_total"),
+		"Count of actions dropped by an overflow policy.",
+		[]string{"dispatcher"}, nil,
+	)
+	descItems = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "items_total"),
+		"Count of items finished by operation, status, error type and index (empty for succeeded items).",
+		[]string{"dispatcher", "index", "operation", "status", "error_type"}, nil,
+	)
+	descBulkLatency = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "bulk_latency_seconds"),
+		"Latency of bulk requests.",
+		[]string{"dispatcher"}, nil,
+	)
+)
+
+// Collector is a prometheus.Collector which exposes stats of dispatchers labelled with their names.
+type Collector struct {
+	sync.RWMutex
+	dispatchers map[string]esworker.Dispatcher
+}
+
+// Add registers a dispatcher with a name used as the dispatcher label.
+func (c *Collector) Add(name string, d esworker.Dispatcher) error {
+	if name == "" || d == nil {
+		return fmt.Errorf("[err] Add empty params")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.dispatchers[name]; ok {
+		return fmt.Errorf("[err] Add already exist %s", name)
+	}
+	c.dispatchers[name] = d
+	return nil
+}
+
+// Remove unregisters a dispatcher of the name.
+func (c *Collector) Remove(name string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.dispatchers, name)
+}
+
+// Describe sends descriptors of metrics.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descAccepted
+	ch <- descRejected
+	ch <- descQueueDepth
+	ch <- descSpillDepth
+	ch <- descWorkerQueueDepth
+	ch <- descBulks
+	ch <- descBulkFailures
+	ch <- descBytesSent
+	ch <- descRetries
+	ch <- descCoalesced
+	ch <- descDropped
+	ch <- descItems
+	ch <- descBulkLatency
+}
+
+// Collect sends metrics made from stats of every dispatcher.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for name, d := range c.dispatchers {
+		stats := d.Stats()
+		if stats == nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(descAccepted, prometheus.CounterValue, float64(stats.Accepted), name)
+		ch <- prometheus.MustNewConstMetric(descRejected, prometheus.CounterValue, float64(stats.Rejected), name)
+		ch <- prometheus.MustNewConstMetric(descQueueDepth, prometheus.GaugeValue, float64(stats.QueueDepth), name)
+		ch <- prometheus.MustNewConstMetric(descSpillDepth, prometheus.GaugeValue, float64(stats.SpillDepth), name)
+		for i, depth := range stats.WorkerQueueDepth {
+			ch <- prometheus.MustNewConstMetric(descWorkerQueueDepth, prometheus.GaugeValue, float64(depth), name, strconv.Itoa(i))
+		}
+		ch <- prometheus.MustNewConstMetric(descBulks, prometheus.CounterValue, float64(stats.Bulks), name)
+		ch <- prometheus.MustNewConstMetric(descBulkFailures, prometheus.CounterValue, float64(stats.BulkFailures), name)
+		ch <- prometheus.MustNewConstMetric(descBytesSent, prometheus.CounterValue, float64(stats.BytesSent), name)
+		ch <- prometheus.MustNewConstMetric(descRetries, prometheus.CounterValue, float64(stats.Retries), name)
+		ch <- prometheus.MustNewConstMetric(descCoalesced, prometheus.CounterValue, float64(stats.Coalesced), name)
+		ch <- prometheus.MustNewConstMetric(descDropped, prometheus.CounterValue, float64(stats.Dropped), name)
+		for key, count := range stats.Items {
+			ch <- prometheus.MustNewConstMetric(descItems, prometheus.CounterValue, float64(count),
+				name, key.Index, key.Operation, strconv.Itoa(key.Status), key.ErrorType)
+		}
+
+		buckets := make(map[float64]uint64, len(stats.BulkLatency.Buckets))
+		for i, bound := range stats.BulkLatency.Buckets {
+			buckets[bound] = stats.BulkLatency.Counts[i]
+		}
+		ch <- prometheus.MustNewConstHistogram(descBulkLatency, stats.BulkLatency.Count, stats.BulkLatency.Sum, buckets, name)
+	}
+}
+
+// NewCollector is to make Collector.
+func NewCollector() *Collector {
+	return &Collector{dispatchers: make(map[string]esworker.Dispatcher)}
+}
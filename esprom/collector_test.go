@@ -0,0 +1,115 @@
+package esprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gjbae1212/go-esworker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDispatcher struct {
+	esworker.Dispatcher
+	stats *esworker.Stats
+}
+
+func (md *mockDispatcher) Stats() *esworker.Stats {
+	return md.stats
+}
+
+func TestCollector_Add(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCollector()
+	assert.Error(c.Add("", &mockDispatcher{}))
+	assert.Error(c.Add("name", nil))
+	assert.NoError(c.Add("name", &mockDispatcher{}))
+	assert.Error(c.Add("name", &mockDispatcher{}))
+
+	c.Remove("name")
+	assert.NoError(c.Add("name", &mockDispatcher{}))
+}
+
+func TestCollector_Collect(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &mockDispatcher{stats: &esworker.Stats{
+		Accepted:         10,
+		Rejected:         1,
+		QueueDepth:       3,
+		WorkerQueueDepth: []int{1, 2},
+		Bulks:            2,
+		BytesSent:        1024,
+		Retries:          1,
+		Coalesced:        4,
+		Dropped:          5,
+		SpillDepth:       6,
+		Items: map[esworker.ItemStatKey]int64{
+			{Operation: "index", Status: 201}: 5,
+			{Index: "allan", Operation: "create", Status: 409, ErrorType: "version_conflict_engine_exception"}: 1,
+		},
+		BulkLatency: esworker.Histogram{
+			Buckets: []float64{0.1, 1},
+			Counts:  []uint64{1, 2},
+			Count:   2,
+			Sum:     0.6,
+		},
+	}}
+
+	c := NewCollector()
+	assert.NoError(c.Add("main", d))
+	assert.NoError(c.Add("empty", &mockDispatcher{}))
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(registry.Register(c))
+
+	expected := `
+# HELP esworker_actions_accepted_total Count of actions accepted by AddAction.
+# TYPE esworker_actions_accepted_total counter
+esworker_actions_accepted_total{dispatcher="main"} 10
+# HELP esworker_actions_coalesced_total Count of actions folded into a queued action on the same document.
+# TYPE esworker_actions_coalesced_total counter
+esworker_actions_coalesced_total{dispatcher="main"} 4
+# HELP esworker_actions_dropped_total Count of actions dropped by an overflow policy.
+# TYPE esworker_actions_dropped_total counter
+esworker_actions_dropped_total{dispatcher="main"} 5
+# HELP esworker_spill_depth Count of actions waiting in spill files.
+# TYPE esworker_spill_depth gauge
+esworker_spill_depth{dispatcher="main"} 6
+# HELP esworker_items_total Count of items finished by operation, status, error type and index (empty for succeeded items).
+# TYPE esworker_items_total counter
+esworker_items_total{dispatcher="main",error_type="",index="",operation="index",status="201"} 5
+esworker_items_total{dispatcher="main",error_type="version_conflict_engine_exception",index="allan",operation="create",status="409"} 1
+# HELP esworker_worker_queue_depth Count of actions waiting in a worker queue.
+# TYPE esworker_worker_queue_depth gauge
+esworker_worker_queue_depth{dispatcher="main",worker="0"} 1
+esworker_worker_queue_depth{dispatcher="main",worker="1"} 2
+# HELP esworker_bulk_latency_seconds Latency of bulk requests.
+# TYPE esworker_bulk_latency_seconds histogram
+esworker_bulk_latency_seconds_bucket{dispatcher="main",le="0.1"} 1
+esworker_bulk_latency_seconds_bucket{dispatcher="main",le="1"} 2
+esworker_bulk_latency_seconds_bucket{dispatcher="main",le="+Inf"} 2
+esworker_bulk_latency_seconds_sum{dispatcher="main"} 0.6
+esworker_bulk_latency_seconds_count{dispatcher="main"} 2
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"esworker_actions_accepted_total",
+		"esworker_actions_coalesced_total",
+		"esworker_actions_dropped_total",
+		"esworker_spill_depth",
+		"esworker_items_total",
+		"esworker_worker_queue_depth",
+		"esworker_bulk_latency_seconds",
+	)
+	assert.NoError(err)
+
+	// a real dispatcher
+	dp, err := esworker.NewDispatcher()
+	assert.NoError(err)
+	assert.NoError(c.Add("real", dp))
+	count, err := testutil.GatherAndCount(registry, "esworker_queue_depth")
+	assert.NoError(err)
+	assert.Equal(2, count)
+}
@@ -0,0 +1,13 @@
+module github.com/gjbae1212/go-esworker/esprom
+
+go 1.13
+
+require (
+	github.com/gjbae1212/go-esworker v0.0.0-20261016231455-60a3a2218100
+	github.com/prometheus/client_golang v1.7.1
+	github.com/stretchr/testify v1.7.0
+)
+
+// the core package is developed in the same repository, and it is used in place of the required version locally.
+// a replace is ignored when esprom is a dependency, so the required version should include stats used by esprom.
+replace github.com/gjbae1212/go-esworker => ../
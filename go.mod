@@ -3,9 +3,16 @@ module github.com/gjbae1212/go-esworker
 go 1.13
 
 require (
+	github.com/elastic/elastic-transport-go/v8 v8.1.0
 	github.com/elastic/go-elasticsearch/v5 v5.6.1
 	github.com/elastic/go-elasticsearch/v6 v6.8.10
 	github.com/elastic/go-elasticsearch/v7 v7.9.0
-	github.com/stretchr/testify v1.3.0
+	github.com/elastic/go-elasticsearch/v8 v8.4.0
+	github.com/goccy/go-json v0.10.2
+	github.com/golang/protobuf v1.4.2 // indirect
+	github.com/opensearch-project/opensearch-go v1.1.0
+	github.com/sirupsen/logrus v1.4.2 // indirect
+	github.com/stretchr/testify v1.7.0
 	github.com/testcontainers/testcontainers-go v0.0.5
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"io"
 
+	es8_logger "github.com/elastic/elastic-transport-go/v8/elastictransport"
 	es5_logger "github.com/elastic/go-elasticsearch/v5/estransport"
 	es6_logger "github.com/elastic/go-elasticsearch/v6/estransport"
 	es7_logger "github.com/elastic/go-elasticsearch/v7/estransport"
+	os_logger "github.com/opensearch-project/opensearch-go/opensearchtransport"
 )
 
 type LoggerType int
@@ -110,6 +112,60 @@ func (logger *Logger) GetESLogger(v ESVersion) (interface{}, error) {
 				EnableResponseBody: logger.EnableResponseBody,
 			}, nil
 		}
+	case V8:
+		switch logger.Type {
+		case LOGGER_TYPE_TEXT:
+			return &es8_logger.TextLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		case LOGGER_TYPE_COLOR:
+			return &es8_logger.ColorLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		case LOGGER_TYPE_CURL:
+			return &es8_logger.CurlLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		case LOGGER_TYPE_JSON:
+			return &es8_logger.JSONLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		}
+	case OpenSearch:
+		switch logger.Type {
+		case LOGGER_TYPE_TEXT:
+			return &os_logger.TextLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		case LOGGER_TYPE_COLOR:
+			return &os_logger.ColorLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		case LOGGER_TYPE_CURL:
+			return &os_logger.CurlLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		case LOGGER_TYPE_JSON:
+			return &os_logger.JSONLogger{
+				Output:             logger.Output,
+				EnableRequestBody:  logger.EnableRequestBody,
+				EnableResponseBody: logger.EnableResponseBody,
+			}, nil
+		}
 	}
 	return nil, fmt.Errorf("[err] not support es version %s", v.GetString())
 }
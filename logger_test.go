@@ -4,9 +4,11 @@ import (
 	"reflect"
 	"testing"
 
+	es8_logger "github.com/elastic/elastic-transport-go/v8/elastictransport"
 	es5_logger "github.com/elastic/go-elasticsearch/v5/estransport"
 	es6_logger "github.com/elastic/go-elasticsearch/v6/estransport"
 	es7_logger "github.com/elastic/go-elasticsearch/v7/estransport"
+	os_logger "github.com/opensearch-project/opensearch-go/opensearchtransport"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,6 +41,16 @@ func TestLogger_GetESLogger(t *testing.T) {
 			input:      &Logger{Type: LOGGER_TYPE_CURL},
 			outputType: reflect.TypeOf(&es7_logger.CurlLogger{}),
 		},
+		"es8-text": {
+			v:          V8,
+			input:      &Logger{Type: LOGGER_TYPE_TEXT, EnableRequestBody: true},
+			outputType: reflect.TypeOf(&es8_logger.TextLogger{}),
+		},
+		"opensearch-json": {
+			v:          OpenSearch,
+			input:      &Logger{Type: LOGGER_TYPE_JSON, EnableResponseBody: true},
+			outputType: reflect.TypeOf(&os_logger.JSONLogger{}),
+		},
 	}
 
 	for _, t := range tests {
@@ -55,6 +67,12 @@ func TestLogger_GetESLogger(t *testing.T) {
 		case V7:
 			assert.Equal(t.input.EnableRequestBody, result.(es6_logger.Logger).RequestBodyEnabled())
 			assert.Equal(t.input.EnableResponseBody, result.(es6_logger.Logger).ResponseBodyEnabled())
+		case V8:
+			assert.Equal(t.input.EnableRequestBody, result.(es8_logger.Logger).RequestBodyEnabled())
+			assert.Equal(t.input.EnableResponseBody, result.(es8_logger.Logger).ResponseBodyEnabled())
+		case OpenSearch:
+			assert.Equal(t.input.EnableRequestBody, result.(os_logger.Logger).RequestBodyEnabled())
+			assert.Equal(t.input.EnableResponseBody, result.(os_logger.Logger).ResponseBodyEnabled())
 		}
 	}
 
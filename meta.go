@@ -0,0 +1,129 @@
+package esworker
+
+import (
+	"fmt"
+)
+
+// ActionMeta is optional metadata of an action in a bulk request.
+type ActionMeta struct {
+	Routing         string `json:"routing,omitempty"`           // shard routing value.
+	Parent          string `json:"parent,omitempty"`            // parent id (not supported since v7, use Routing for join).
+	Version         int64  `json:"version,omitempty"`           // version of a document (ignored if 0).
+	VersionType     string `json:"version_type,omitempty"`      // internal, external, external_gte.
+	IfSeqNo         *int64 `json:"if_seq_no,omitempty"`         // optimistic concurrency with IfPrimaryTerm (v6.7+).
+	IfPrimaryTerm   *int64 `json:"if_primary_term,omitempty"`   // optimistic concurrency with IfSeqNo (v6.7+).
+	Pipeline        string `json:"pipeline,omitempty"`          // ingest pipeline.
+	RequireAlias    bool   `json:"require_alias,omitempty"`     // an index must be an alias (v7.10+).
+	RetryOnConflict int    `json:"retry_on_conflict,omitempty"` // how many times to retry on version conflicts (only update).
+}
+
+// MetaAction is an action which has metadata in a bulk request.
+type MetaAction interface {
+	Action
+	GetMeta() *ActionMeta
+}
+
+// bulkMeta is a metadata line of an action in a bulk body since v6.
+type bulkMeta struct {
+	Index           string `json:"_index"`
+	DocType         string `json:"_type,omitempty"`
+	Id              string `json:"_id,omitempty"`
+	Routing         string `json:"routing,omitempty"`
+	Parent          string `json:"parent,omitempty"`
+	Version         int64  `json:"version,omitempty"`
+	VersionType     string `json:"version_type,omitempty"`
+	IfSeqNo         *int64 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm   *int64 `json:"if_primary_term,omitempty"`
+	Pipeline        string `json:"pipeline,omitempty"`
+	RequireAlias    bool   `json:"require_alias,omitempty"`
+	RetryOnConflict int    `json:"retry_on_conflict,omitempty"`
+}
+
+// bulkMetaV5 is a metadata line of an action in a bulk body at v5, whose fields are prefixed with an underscore.
+type bulkMetaV5 struct {
+	Index           string `json:"_index"`
+	DocType         string `json:"_type,omitempty"`
+	Id              string `json:"_id,omitempty"`
+	Routing         string `json:"_routing,omitempty"`
+	Parent          string `json:"_parent,omitempty"`
+	Version         int64  `json:"_version,omitempty"`
+	VersionType     string `json:"_version_type,omitempty"`
+	Pipeline        string `json:"pipeline,omitempty"`
+	RetryOnConflict int    `json:"_retry_on_conflict,omitempty"`
+}
+
+// getActionMeta returns metadata of an action (nil if it has none).
+func getActionMeta(act Action) *ActionMeta {
+	if ma, ok := act.(MetaAction); ok {
+		return ma.GetMeta()
+	}
+	return nil
+}
+
+// validate checks whether metadata is supported on the version and the operation.
+func (meta *ActionMeta) validate(v ESVersion, op ESOperation) error {
+	if meta == nil {
+		return nil
+	}
+
+	if (meta.IfSeqNo == nil) != (meta.IfPrimaryTerm == nil) {
+		return fmt.Errorf("[err] meta (if_seq_no and if_primary_term should be used together)")
+	}
+	if meta.RetryOnConflict > 0 && op != ES_UPDATE {
+		return fmt.Errorf("[err] meta (retry_on_conflict is only for update)")
+	}
+
+	switch v {
+	case V5:
+		if meta.IfSeqNo != nil {
+			return fmt.Errorf("[err] meta (if_seq_no is not supported on %s)", v.GetString())
+		}
+		if meta.RequireAlias {
+			return fmt.Errorf("[err] meta (require_alias is not supported on %s)", v.GetString())
+		}
+	case V6:
+		if meta.RequireAlias {
+			return fmt.Errorf("[err] meta (require_alias is not supported on %s)", v.GetString())
+		}
+	case V7, V8, OpenSearch:
+		if meta.Parent != "" {
+			return fmt.Errorf("[err] meta (parent is not supported on %s)", v.GetString())
+		}
+	}
+	return nil
+}
+
+// newBulkMeta makes a metadata line of an action depending on a version.
+func newBulkMeta(v ESVersion, index, docType, id string, meta *ActionMeta) interface{} {
+	if meta == nil {
+		meta = &ActionMeta{}
+	}
+
+	if v == V5 {
+		return &bulkMetaV5{
+			Index:           index,
+			DocType:         docType,
+			Id:              id,
+			Routing:         meta.Routing,
+			Parent:          meta.Parent,
+			Version:         meta.Version,
+			VersionType:     meta.VersionType,
+			Pipeline:        meta.Pipeline,
+			RetryOnConflict: meta.RetryOnConflict,
+		}
+	}
+	return &bulkMeta{
+		Index:           index,
+		DocType:         docType,
+		Id:              id,
+		Routing:         meta.Routing,
+		Parent:          meta.Parent,
+		Version:         meta.Version,
+		VersionType:     meta.VersionType,
+		IfSeqNo:         meta.IfSeqNo,
+		IfPrimaryTerm:   meta.IfPrimaryTerm,
+		Pipeline:        meta.Pipeline,
+		RequireAlias:    meta.RequireAlias,
+		RetryOnConflict: meta.RetryOnConflict,
+	}
+}
@@ -0,0 +1,60 @@
+package esworker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionMeta_Validate(t *testing.T) {
+	assert := assert.New(t)
+
+	seqNo, primaryTerm := int64(3), int64(1)
+	tests := map[string]struct {
+		version ESVersion
+		op      ESOperation
+		input   *ActionMeta
+		isErr   bool
+	}{
+		"nil":                  {version: V7, op: ES_INDEX, input: nil, isErr: false},
+		"routing":              {version: V5, op: ES_INDEX, input: &ActionMeta{Routing: "r"}, isErr: false},
+		"only if_seq_no":       {version: V7, op: ES_INDEX, input: &ActionMeta{IfSeqNo: &seqNo}, isErr: true},
+		"if_seq_no v7":         {version: V7, op: ES_INDEX, input: &ActionMeta{IfSeqNo: &seqNo, IfPrimaryTerm: &primaryTerm}, isErr: false},
+		"if_seq_no v5":         {version: V5, op: ES_INDEX, input: &ActionMeta{IfSeqNo: &seqNo, IfPrimaryTerm: &primaryTerm}, isErr: true},
+		"require_alias v6":     {version: V6, op: ES_INDEX, input: &ActionMeta{RequireAlias: true}, isErr: true},
+		"require_alias v7":     {version: V7, op: ES_INDEX, input: &ActionMeta{RequireAlias: true}, isErr: false},
+		"parent v6":            {version: V6, op: ES_INDEX, input: &ActionMeta{Parent: "p"}, isErr: false},
+		"parent v7":            {version: V7, op: ES_INDEX, input: &ActionMeta{Parent: "p"}, isErr: true},
+		"retry_on_conflict":    {version: V7, op: ES_UPDATE, input: &ActionMeta{RetryOnConflict: 3}, isErr: false},
+		"retry_on_conflict ix": {version: V7, op: ES_INDEX, input: &ActionMeta{RetryOnConflict: 3}, isErr: true},
+	}
+
+	for _, t := range tests {
+		err := t.input.validate(t.version, t.op)
+		assert.Equal(t.isErr, err != nil)
+	}
+}
+
+func TestNewBulkMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	seqNo, primaryTerm := int64(0), int64(1)
+	tests := map[string]struct {
+		version ESVersion
+		input   *ActionMeta
+		output  string
+	}{
+		"empty": {version: V6, input: nil, output: `{"_index":"allan","_type":"_doc","_id":"1"}`},
+		"v5": {version: V5, input: &ActionMeta{Routing: "r", Parent: "p", Version: 2, VersionType: "external", Pipeline: "pl"},
+			output: `{"_index":"allan","_type":"_doc","_id":"1","_routing":"r","_parent":"p","_version":2,"_version_type":"external","pipeline":"pl"}`},
+		"v7": {version: V7, input: &ActionMeta{Routing: "r", IfSeqNo: &seqNo, IfPrimaryTerm: &primaryTerm, RequireAlias: true},
+			output: `{"_index":"allan","_type":"_doc","_id":"1","routing":"r","if_seq_no":0,"if_primary_term":1,"require_alias":true}`},
+	}
+
+	for _, t := range tests {
+		line, err := json.Marshal(newBulkMeta(t.version, "allan", "_doc", "1", t.input))
+		assert.NoError(err)
+		assert.Equal(t.output, string(line))
+	}
+}
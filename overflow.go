@@ -0,0 +1,162 @@
+package esworker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what AddAction does when a global queue is full.
+type OverflowPolicy int
+
+const (
+	OVERFLOW_BLOCK       OverflowPolicy = iota // wait until a queue has room or ctx is done.
+	OVERFLOW_REJECT                            // return ErrQueueFull immediately.
+	OVERFLOW_DROP_OLDEST                       // drop the oldest action in a queue to make room.
+	OVERFLOW_DROP_NEWEST                       // drop the action being added.
+	OVERFLOW_SPILL                             // spill actions to files on a local disk until a queue has room.
+)
+
+// push puts tasks to queue as a unit, and a policy decides what to do when queue is full.
+// dropped tasks are resolved with an error wrapping ErrQueueFull.
+func (bk *breaker) push(ctx context.Context, tasks []*task, policy OverflowPolicy) error {
+	// while actions are spilled, actions are spilled as well to keep an order.
+	if bk.spill.len() > 0 {
+		return bk.spill.push(tasks...)
+	}
+
+	if bk.offer(tasks) {
+		return nil
+	}
+
+	switch policy {
+	case OVERFLOW_REJECT:
+		return ErrQueueFull
+	case OVERFLOW_DROP_NEWEST:
+		bk.drop(tasks...)
+		return nil
+	case OVERFLOW_DROP_OLDEST:
+		// actions are dropped only when there is no room, so it is tried to push firstly.
+		for !bk.offer(tasks) {
+			if old := bk.poll(); old != nil {
+				bk.drop(old...)
+			}
+		}
+		return nil
+	case OVERFLOW_SPILL:
+		return bk.spill.push(tasks...)
+	}
+
+	atomic.AddInt64(&bk.queued, int64(len(tasks)))
+	select {
+	case bk.queue <- tasks:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&bk.queued, -int64(len(tasks)))
+		return fmt.Errorf("[err] AddAction timeout %w", ErrQueueFull)
+	case <-bk.closing:
+		atomic.AddInt64(&bk.queued, -int64(len(tasks)))
+		return fmt.Errorf("[err] AddAction (dispatcher not running)")
+	}
+}
+
+// offer puts tasks to queue as a unit without blocking, and returns false if queue is full.
+func (bk *breaker) offer(tasks []*task) bool {
+	atomic.AddInt64(&bk.queued, int64(len(tasks)))
+	select {
+	case bk.queue <- tasks:
+		return true
+	default:
+		atomic.AddInt64(&bk.queued, -int64(len(tasks)))
+		return false
+	}
+}
+
+// poll takes the oldest unit of tasks in queue without blocking, and returns nil if queue is empty.
+func (bk *breaker) poll() []*task {
+	select {
+	case tasks := <-bk.queue:
+		bk.received(tasks)
+		return tasks
+	default:
+		return nil
+	}
+}
+
+// received records that tasks are taken from queue.
+func (bk *breaker) received(tasks []*task) {
+	atomic.AddInt64(&bk.queued, -int64(len(tasks)))
+}
+
+// queueDepth returns a count of actions in queue.
+func (bk *breaker) queueDepth() int {
+	return int(atomic.LoadInt64(&bk.queued))
+}
+
+// drop gives up tasks because a queue is full.
+func (bk *breaker) drop(tasks ...*task) {
+	bk.metrics.observeDrop(len(tasks))
+	for _, t := range tasks {
+		bk.wal.ack(t.seq)
+		t.result.resolve(nil, fmt.Errorf("[err] AddAction (dropped) %w", ErrQueueFull))
+	}
+}
+
+// restore pushes spilled tasks back to queue in order until closing.
+// tasks taken from spill files but not pushed yet are left in restoring.
+func (bk *breaker) restore(done chan struct{}) {
+	defer close(done)
+	for {
+		if len(bk.restoring) == 0 {
+			tasks, err := bk.spill.take()
+			if err != nil {
+				bk.errorHandler(err)
+			}
+			if len(tasks) == 0 {
+				select {
+				case <-bk.spill.notify:
+					continue
+				case <-bk.closing:
+					return
+				}
+			}
+			bk.restoring = tasks
+		}
+
+		atomic.AddInt64(&bk.queued, 1)
+		select {
+		case bk.queue <- bk.restoring[:1]:
+			bk.restoring = bk.restoring[1:]
+			bk.spill.restored(1)
+		case <-bk.closing:
+			atomic.AddInt64(&bk.queued, -1)
+			return
+		}
+	}
+}
+
+// pop takes tasks remained in queue or spill files without blocking, and returns nil if nothing remains.
+// it should be called after intake and restoring are stopped.
+func (bk *breaker) pop() []*task {
+	if tasks := bk.poll(); tasks != nil {
+		return tasks
+	}
+	if bk.spill == nil {
+		return nil
+	}
+
+	for len(bk.restoring) == 0 {
+		tasks, err := bk.spill.take()
+		if err != nil {
+			bk.errorHandler(err)
+		}
+		if len(tasks) == 0 && err == nil {
+			return nil
+		}
+		bk.restoring = tasks
+	}
+	tasks := bk.restoring
+	bk.restoring = nil
+	bk.spill.restored(len(tasks))
+	return tasks
+}
@@ -0,0 +1,123 @@
+package esworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker_Push(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-overflow")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	newTask := func(id int) *task {
+		return &task{act: &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", id)}, result: newActionResult()}
+	}
+	ids := func(bk *breaker) []string {
+		var ids []string
+		for tasks := bk.pop(); tasks != nil; tasks = bk.pop() {
+			for _, t := range tasks {
+				ids = append(ids, t.act.GetID())
+			}
+		}
+		return ids
+	}
+
+	tests := map[string]struct {
+		policy  OverflowPolicy
+		isErr   bool
+		dropped []int
+		remain  []string
+	}{
+		"block":       {policy: OVERFLOW_BLOCK, isErr: true, remain: []string{"0", "1"}},
+		"reject":      {policy: OVERFLOW_REJECT, isErr: true, remain: []string{"0", "1"}},
+		"drop oldest": {policy: OVERFLOW_DROP_OLDEST, dropped: []int{0}, remain: []string{"1", "2"}},
+		"drop newest": {policy: OVERFLOW_DROP_NEWEST, dropped: []int{2}, remain: []string{"0", "1"}},
+		"spill":       {policy: OVERFLOW_SPILL, remain: []string{"0", "1", "2"}},
+	}
+
+	for name, t := range tests {
+		cfg := testCfg(V7)
+		WithGlobalQueueSizeOption(2).apply(cfg)
+		WithOverflowPolicyOption(t.policy).apply(cfg)
+		WithOverflowSpillDirOption(dir).apply(cfg)
+		bk, err := createBreaker(cfg)
+		assert.NoError(err, name)
+		bk.closing = make(chan struct{})
+		if bk.spill != nil {
+			assert.NoError(bk.spill.open(), name)
+		}
+
+		tasks := []*task{newTask(0), newTask(1), newTask(2)}
+		assert.NoError(bk.push(context.Background(), tasks[:1], t.policy), name)
+		assert.NoError(bk.push(context.Background(), tasks[1:2], t.policy), name)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err = bk.push(ctx, tasks[2:], t.policy)
+		cancel()
+		assert.Equal(t.isErr, err != nil, name)
+		if err != nil {
+			assert.True(errors.Is(err, ErrQueueFull), name)
+		}
+
+		assert.Equal(int64(len(t.dropped)), bk.metrics.snapshot().Dropped, name)
+		for _, i := range t.dropped {
+			_, err := tasks[i].result.Wait(context.Background())
+			assert.True(errors.Is(err, ErrQueueFull), name)
+		}
+		assert.Equal(t.remain, ids(bk), name)
+	}
+
+	// while actions are spilled, an action is spilled as well even if queue has room.
+	cfg := testCfg(V7)
+	WithGlobalQueueSizeOption(1).apply(cfg)
+	WithOverflowPolicyOption(OVERFLOW_SPILL).apply(cfg)
+	WithOverflowSpillDirOption(dir).apply(cfg)
+	bk, err := createBreaker(cfg)
+	assert.NoError(err)
+	assert.NoError(bk.spill.open())
+	for i := 0; i < 3; i++ {
+		assert.NoError(bk.push(context.Background(), []*task{newTask(i)}, OVERFLOW_SPILL))
+	}
+	bk.poll()
+	assert.NoError(bk.push(context.Background(), []*task{newTask(3)}, OVERFLOW_SPILL))
+	assert.Equal(3, bk.spill.len())
+	assert.Equal([]string{"1", "2", "3"}, ids(bk))
+	assert.Equal(0, bk.spill.len())
+
+	// a closing breaker doesn't block.
+	cfg = testCfg(V7)
+	WithGlobalQueueSizeOption(1).apply(cfg)
+	bk, err = createBreaker(cfg)
+	assert.NoError(err)
+	bk.closing = make(chan struct{})
+	assert.NoError(bk.push(context.Background(), []*task{newTask(0)}, OVERFLOW_BLOCK))
+	close(bk.closing)
+	err = bk.push(context.Background(), []*task{newTask(1)}, OVERFLOW_BLOCK)
+	assert.Error(err)
+	assert.False(errors.Is(err, ErrQueueFull))
+
+	// tasks pushed together take a slot in queue, and they are taken together.
+	cfg = testCfg(V7)
+	WithGlobalQueueSizeOption(1).apply(cfg)
+	bk, err = createBreaker(cfg)
+	assert.NoError(err)
+	assert.NoError(bk.push(context.Background(), []*task{newTask(0), newTask(1), newTask(2)}, OVERFLOW_REJECT))
+	assert.Equal(3, bk.queueDepth())
+	assert.Equal(ErrQueueFull, bk.push(context.Background(), []*task{newTask(3)}, OVERFLOW_REJECT))
+	assert.Equal([]string{"0", "1", "2"}, ids(bk))
+	assert.Equal(0, bk.queueDepth())
+
+	// a spill directory is required.
+	_, err = NewDispatcher(WithOverflowPolicyOption(OVERFLOW_SPILL))
+	assert.Error(err)
+}
@@ -0,0 +1,36 @@
+package esworker
+
+import (
+	"hash/fnv"
+)
+
+// PartitionFunc returns a partition key of an action, and actions with the same key are processed by the same worker in order.
+// an action with an empty key is not partitioned, so it is handed over to workers in turn.
+type PartitionFunc func(act Action) string
+
+// PartitionByDocument is a PartitionFunc which keys an action by its index and id.
+// an action without an id is not partitioned because its id is generated by Elasticsearch.
+func PartitionByDocument(act Action) string {
+	if act.GetID() == "" {
+		return ""
+	}
+	return act.GetIndex() + "\x00" + act.GetID()
+}
+
+// partitioner routes tasks to workers by partition keys.
+type partitioner struct {
+	key  PartitionFunc
+	next int // a worker receiving a task with an empty key next.
+}
+
+// route returns an index of a worker which should process a task among n workers.
+func (p *partitioner) route(t *task, n int) int {
+	key := p.key(t.act)
+	if key == "" {
+		p.next = (p.next + 1) % n
+		return p.next
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
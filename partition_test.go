@@ -0,0 +1,52 @@
+package esworker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionByDocument(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input  Action
+		output string
+	}{
+		"id":    {input: &mockAction{op: ES_INDEX, index: "allan", id: "1"}, output: "allan\x001"},
+		"no id": {input: &mockAction{op: ES_INDEX, index: "allan"}, output: ""},
+	}
+
+	for name, t := range tests {
+		assert.Equal(t.output, PartitionByDocument(t.input), name)
+	}
+}
+
+func TestPartitioner_Route(t *testing.T) {
+	assert := assert.New(t)
+
+	p := &partitioner{key: PartitionByDocument}
+
+	// the same key is always routed to the same worker.
+	routed := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		act := &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}
+		n := p.route(&task{act: act}, 4)
+		assert.Equal(n, p.route(&task{act: &mockAction{op: ES_DELETE, index: "allan", id: act.id}}, 4))
+		assert.True(n >= 0 && n < 4)
+		routed[n] = true
+	}
+	assert.Len(routed, 4)
+
+	// an empty key is routed to workers in turn.
+	var turns []int
+	for i := 0; i < 5; i++ {
+		turns = append(turns, p.route(&task{act: &mockAction{op: ES_INDEX, index: "allan"}}, 3))
+	}
+	assert.Equal([]int{1, 2, 0, 1, 2}, turns)
+
+	// a custom key
+	p = &partitioner{key: func(act Action) string { return act.GetIndex() }}
+	assert.Equal(p.route(&task{act: &mockAction{index: "allan", id: "1"}}, 8), p.route(&task{act: &mockAction{index: "allan", id: "2"}}, 8))
+}
@@ -0,0 +1,55 @@
+package esworker
+
+// RawAction is a struct to implement an interface of RawDocAction, and its document is already serialized to JSON.
+// actions replayed from a wal or dead letters are restored as RawAction, which writes stored documents as they are.
+type RawAction struct {
+	Op      ESOperation
+	Index   string
+	DocType string
+	Id      string
+	Doc     []byte      // a document as JSON (an update body if an operation is an update).
+	Meta    *ActionMeta // optional metadata such as routing and version.
+}
+
+// GetOperation returns an operation to process a document.
+func (ra *RawAction) GetOperation() ESOperation {
+	return ra.Op
+}
+
+// GetIndex returns an index that want to insert on Elasticsearch.
+func (ra *RawAction) GetIndex() string {
+	return ra.Index
+}
+
+// GetDocType returns a doctype that want to insert on Elasticsearch.
+func (ra *RawAction) GetDocType() string {
+	return ra.DocType
+}
+
+// GetID returns an id for document on Elasticsearch.
+func (ra *RawAction) GetID() string {
+	return ra.Id
+}
+
+// GetRawDoc returns a document as JSON, which is written to a bulk body.
+func (ra *RawAction) GetRawDoc() []byte {
+	return ra.Doc
+}
+
+// GetDoc decodes a document to a map, and numbers are kept as json.Number not to lose precision.
+// it returns nil if a document is not a JSON object.
+func (ra *RawAction) GetDoc() map[string]interface{} {
+	if len(ra.Doc) == 0 {
+		return nil
+	}
+	doc := map[string]interface{}{}
+	if err := decodeNumber(ra.Doc, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// GetMeta returns metadata of a bulk request.
+func (ra *RawAction) GetMeta() *ActionMeta {
+	return ra.Meta
+}
@@ -0,0 +1,57 @@
+package esworker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawAction_GetOperation(t *testing.T) {
+	assert := assert.New(t)
+	ra := &RawAction{Op: ES_UPDATE}
+	assert.Equal(ES_UPDATE, ra.GetOperation())
+}
+
+func TestRawAction_GetIndex(t *testing.T) {
+	assert := assert.New(t)
+	ra := &RawAction{Index: "index"}
+	assert.Equal("index", ra.GetIndex())
+}
+
+func TestRawAction_GetDocType(t *testing.T) {
+	assert := assert.New(t)
+	ra := &RawAction{DocType: "_doc"}
+	assert.Equal("_doc", ra.GetDocType())
+}
+
+func TestRawAction_GetID(t *testing.T) {
+	assert := assert.New(t)
+	ra := &RawAction{Id: "id"}
+	assert.Equal("id", ra.GetID())
+}
+
+func TestRawAction_GetDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input  []byte
+		output map[string]interface{}
+	}{
+		"empty":      {input: nil, output: nil},
+		"not object": {input: []byte(`[1, 2]`), output: nil},
+		"large int":  {input: []byte(`{"id": 9007199254740993}`), output: map[string]interface{}{"id": json.Number("9007199254740993")}},
+	}
+
+	for _, t := range tests {
+		ra := &RawAction{Doc: t.input}
+		assert.Equal(t.input, ra.GetRawDoc())
+		assert.Equal(t.output, ra.GetDoc())
+	}
+}
+
+func TestRawAction_GetMeta(t *testing.T) {
+	assert := assert.New(t)
+	ra := &RawAction{Meta: &ActionMeta{Routing: "user1"}}
+	assert.Equal("user1", ra.GetMeta().Routing)
+}
@@ -0,0 +1,98 @@
+package esworker
+
+import (
+	"sync"
+)
+
+// resizeRequest asks the booking loop to change a count of workers.
+type resizeRequest struct {
+	size    int
+	added   []*worker // workers to be added, which are created in advance.
+	running bool      // whether workers are running.
+	done    chan struct{}
+}
+
+// setWorkerCount creates workers to be added, and replaces workers in the booking loop while running.
+func (bk *breaker) setWorkerCount(n int) error {
+	req := &resizeRequest{size: n, running: bk.running, done: make(chan struct{})}
+	for i := len(bk.workers); i < n; i++ {
+		w, err := createWorker(bk.cfg, i, nil, bk.wal, bk.metrics)
+		if err != nil {
+			return err
+		}
+		req.added = append(req.added, w)
+	}
+
+	if !req.running {
+		bk.resizeWorkers(req)
+		return nil
+	}
+	bk.resize <- req
+	<-req.done
+	return nil
+}
+
+// resizeWorkers retires workers over a size, and adds workers.
+// while running, it is called in the booking loop, so no task is handed over during resizing.
+func (bk *breaker) resizeWorkers(req *resizeRequest) {
+	size := req.size
+	if size > len(bk.workers) {
+		size = len(bk.workers)
+	}
+	retained, retired := bk.workers[:size:size], bk.workers[size:]
+
+	if req.running {
+		// in a partitioning mode, keys are routed to other workers after resizing,
+		// so every worker sends actions in its queue in advance to keep an order of actions with the same key.
+		if bk.partitioner != nil && len(bk.workers) != req.size {
+			flush := &flushRequest{ctx: bk.ctx}
+			flush.wg.Add(len(bk.workers))
+			for _, w := range bk.workers {
+				w.flush <- flush
+			}
+			flush.wg.Wait()
+		}
+
+		// retired workers stop after sending actions in their queues.
+		wg := sync.WaitGroup{}
+		for _, w := range retired {
+			wg.Add(1)
+			go func(w *worker) {
+				defer wg.Done()
+				w.stop()
+			}(w)
+		}
+		wg.Wait()
+	}
+
+	// a pool is replaced to have room for pipes of all workers, and pipes of retired workers are taken out of it.
+	var pool chan chan []*task
+	if bk.pool != nil {
+		pool = make(chan chan []*task, req.size)
+		for _, w := range retained {
+			w.setPool(pool)
+		}
+		retiredPipes := make(map[chan []*task]bool, len(retired))
+		for _, w := range retired {
+			retiredPipes[w.pipe] = true
+		}
+		for n := len(bk.pool); n > 0; n-- {
+			if pipe := <-bk.pool; !retiredPipes[pipe] {
+				pool <- pipe
+			}
+		}
+	}
+
+	for _, w := range req.added {
+		w.pool = pool
+		if req.running {
+			w.ctx = bk.ctx
+			go w.start()
+		}
+	}
+
+	bk.resizing.Lock()
+	bk.workers = append(retained, req.added...)
+	bk.pool = pool
+	bk.resizing.Unlock()
+}
@@ -0,0 +1,48 @@
+package esworker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker_ResizeWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		partition PartitionFunc
+	}{
+		"pool":      {},
+		"partition": {partition: PartitionByDocument},
+	}
+
+	for name, t := range tests {
+		cfg := testCfg(V7)
+		WithWorkerSizeOption(2).apply(cfg)
+		WithBackend(&mockBackend{}).apply(cfg)
+		if t.partition != nil {
+			WithPartitionOption(t.partition).apply(cfg)
+		}
+		bk, err := createBreaker(cfg)
+		assert.NoError(err, name)
+		first := bk.workers[0]
+
+		for _, size := range []int{4, 1, 3} {
+			assert.NoError(bk.setWorkerCount(size), name)
+			assert.Len(bk.workers, size, name)
+			assert.Equal(first, bk.workers[0], name)
+			for i, w := range bk.workers {
+				assert.Equal(i, w.id, name)
+				assert.Equal(bk.pool, w.pool, name)
+				if t.partition != nil {
+					assert.Equal(cfg.workerQueueSize, cap(w.pipe), name)
+				}
+			}
+			if t.partition == nil {
+				assert.Equal(size, cap(bk.pool), name)
+			} else {
+				assert.Nil(bk.pool, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package esworker
+
+import (
+	"context"
+	"sync"
+)
+
+// ActionResult is a handle which is resolved with a result of an action when a bulk containing it completes.
+type ActionResult struct {
+	once   sync.Once
+	done   chan struct{}
+	status *ESResponseStatus
+	err    error
+}
+
+// Done returns a channel which is closed when the result is resolved.
+func (ar *ActionResult) Done() <-chan struct{} {
+	return ar.done
+}
+
+// Wait blocks until the result is resolved or ctx is done.
+// it returns an item status of the action, and an error if the action is failed.
+// (the status could be nil if a whole bulk request is failed.)
+func (ar *ActionResult) Wait(ctx context.Context) (*ESResponseStatus, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-ar.done:
+		return ar.status, ar.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve settles the result only once.
+func (ar *ActionResult) resolve(status *ESResponseStatus, err error) {
+	if ar == nil {
+		return
+	}
+	ar.once.Do(func() {
+		ar.status = status
+		ar.err = err
+		close(ar.done)
+	})
+}
+
+// newActionResult is to make ActionResult.
+func newActionResult() *ActionResult {
+	return &ActionResult{done: make(chan struct{})}
+}
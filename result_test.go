@@ -0,0 +1,43 @@
+package esworker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionResult_Wait(t *testing.T) {
+	assert := assert.New(t)
+
+	// timeout
+	result := newActionResult()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := result.Wait(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// success
+	go result.resolve(&ESResponseStatus{Id: "1", Status: 201, Version: 1, SeqNo: 3}, nil)
+	status, err := result.Wait(context.Background())
+	assert.NoError(err)
+	assert.Equal("1", status.Id)
+	assert.Equal(int64(1), status.Version)
+	assert.Equal(int64(3), status.SeqNo)
+
+	// resolved only once.
+	result.resolve(nil, fmt.Errorf("error"))
+	select {
+	case <-result.Done():
+	default:
+		assert.Fail("not resolved")
+	}
+	status, err = result.Wait(nil)
+	assert.NoError(err)
+	assert.Equal(201, status.Status)
+
+	var empty *ActionResult
+	empty.resolve(nil, nil)
+}
@@ -0,0 +1,86 @@
+package esworker
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+var (
+	defaultRetryMaxAttempts     = 3
+	defaultRetryInitialInterval = time.Duration(100 * time.Millisecond)
+	defaultRetryMaxInterval     = time.Duration(5 * time.Second)
+	defaultRetryMultiplier      = 2.0
+	defaultRetryJitter          = 0.2
+	defaultRetryPolicy          = RetryOnStatus(RetryStatusNoResponse, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+)
+
+// RetryStatusNoResponse is a status given to RetryPolicy when a bulk request is failed without a response,
+// such as connection refused or timeout.
+const RetryStatusNoResponse = 0
+
+// RetryPolicy decides whether an item which failed with the status could be retried.
+// if a bulk request is failed as a whole, every item in it is decided with a http status of the response
+// (RetryStatusNoResponse if there is no response).
+type RetryPolicy func(status int) bool
+
+// RetryOnStatus returns a RetryPolicy which retries items failed with one of statuses.
+func RetryOnStatus(statuses ...int) RetryPolicy {
+	m := make(map[int]bool, len(statuses))
+	for _, status := range statuses {
+		m[status] = true
+	}
+	return func(status int) bool {
+		return m[status]
+	}
+}
+
+// retrier decides which failed item is retried and how long a worker waits before retrying.
+type retrier struct {
+	maxAttempts     int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	multiplier      float64
+	jitter          float64
+	policy          RetryPolicy
+}
+
+// retryable returns whether an item which has been sent attempts times could be sent again.
+func (r *retrier) retryable(attempts, status int) bool {
+	if r == nil || r.policy == nil {
+		return false
+	}
+	return attempts < r.maxAttempts && r.policy(status)
+}
+
+// backoff returns a wait duration before sending an item which has been sent attempts times.
+func (r *retrier) backoff(attempts int) time.Duration {
+	if r == nil || attempts < 1 {
+		return 0
+	}
+
+	interval := float64(r.initialInterval) * math.Pow(r.multiplier, float64(attempts-1))
+	if r.maxInterval > 0 && interval > float64(r.maxInterval) {
+		interval = float64(r.maxInterval)
+	}
+
+	// spread out retries of workers to avoid hitting the cluster at the same time.
+	if r.jitter > 0 {
+		delta := r.jitter * interval
+		interval = interval - delta + rand.Float64()*(2*delta)
+	}
+	return time.Duration(interval)
+}
+
+// createRetrier is to make retrier.
+func createRetrier(cfg *config) *retrier {
+	return &retrier{
+		maxAttempts:     cfg.retryMaxAttempts,
+		initialInterval: cfg.retryInitialInterval,
+		maxInterval:     cfg.retryMaxInterval,
+		multiplier:      cfg.retryMultiplier,
+		jitter:          cfg.retryJitter,
+		policy:          cfg.retryPolicy,
+	}
+}
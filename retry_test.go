@@ -0,0 +1,76 @@
+package esworker
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryOnStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := RetryOnStatus(http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	assert.True(policy(http.StatusTooManyRequests))
+	assert.True(policy(http.StatusServiceUnavailable))
+	assert.False(policy(http.StatusBadRequest))
+	assert.False(policy(http.StatusConflict))
+}
+
+func TestRetrier_Retryable(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &retrier{maxAttempts: 3, policy: defaultRetryPolicy}
+
+	tests := map[string]struct {
+		attempts int
+		status   int
+		output   bool
+	}{
+		"first":       {attempts: 1, status: 429, output: true},
+		"second":      {attempts: 2, status: 503, output: true},
+		"exhausted":   {attempts: 3, status: 429, output: false},
+		"not-allowed": {attempts: 1, status: 400, output: false},
+		"no response": {attempts: 1, status: RetryStatusNoResponse, output: true},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, r.retryable(t.attempts, t.status))
+	}
+
+	var empty *retrier
+	assert.False(empty.retryable(1, 429))
+}
+
+func TestRetrier_Backoff(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &retrier{
+		initialInterval: 100 * time.Millisecond,
+		maxInterval:     time.Second,
+		multiplier:      2,
+	}
+
+	tests := map[string]struct {
+		attempts int
+		output   time.Duration
+	}{
+		"none":   {attempts: 0, output: 0},
+		"first":  {attempts: 1, output: 100 * time.Millisecond},
+		"second": {attempts: 2, output: 200 * time.Millisecond},
+		"third":  {attempts: 3, output: 400 * time.Millisecond},
+		"max":    {attempts: 10, output: time.Second},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, r.backoff(t.attempts))
+	}
+
+	// jitter
+	r.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := r.backoff(2)
+		assert.True(d >= 100*time.Millisecond && d <= 300*time.Millisecond)
+	}
+}
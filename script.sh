@@ -8,10 +8,14 @@ function test_without_container
 {
     export WITHOUT_CONTAINER=TRUE
     go test -v $(go list ./... | grep -v vendor) --count 1 -race -coverprofile=$CURRENT/coverage.txt -covermode=atomic
+    # esprom is a separate module.
+    (cd $CURRENT/esprom && go test -v ./... --count 1 -race)
 }
 
 function test {
     go test -v $(go list ./... | grep -v vendor) --count 1 -race -coverprofile=$CURRENT/coverage.txt -covermode=atomic
+    # esprom is a separate module.
+    (cd $CURRENT/esprom && go test -v ./... --count 1 -race)
 }
 
 function bench
@@ -0,0 +1,251 @@
+package esworker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	spillSegmentExt = ".spill"
+)
+
+var (
+	defaultSpillSegmentSize = int64(16 * 1024 * 1024)
+)
+
+// spillRecord is a task stored in a spill file.
+type spillRecord struct {
+	walRecord
+	Seq uint64 `json:"seq,omitempty"` // sequence in a write-ahead log (0 if it isn't logged).
+}
+
+// spillSegment is a file of spilled tasks.
+type spillSegment struct {
+	path    string
+	results []*ActionResult // results of tasks in order, which are kept in memory.
+}
+
+// spill keeps tasks overflowing a global queue in segment files on a local disk, and gives them back in order.
+// unlike a write-ahead log, spilled tasks are not kept across restarts.
+type spill struct {
+	sync.Mutex
+	version     ESVersion // a document is stored as it is sent on the version.
+	codec       Codec     // a codec serializing documents.
+	dir         string
+	segmentSize int64
+	segments    []*spillSegment // the last one is written while file is opened.
+	file        *os.File
+	writer      *bufio.Writer
+	size        int64
+	nextID      uint64
+	pending     int64         // tasks spilled and not restored to a queue yet.
+	notify      chan struct{} // it is signaled when a task is spilled.
+}
+
+// open removes spill files remained from a previous run, and prepares to spill.
+func (s *spill) open() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if err := s.removeAll(); err != nil {
+		return err
+	}
+	s.notify = make(chan struct{}, 1)
+	atomic.StoreInt64(&s.pending, 0)
+	return nil
+}
+
+// close removes spill files.
+func (s *spill) close() error {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.removeAll()
+}
+
+// push writes tasks to an active segment.
+// tasks are spilled all together, or none of them is spilled.
+func (s *spill) push(tasks ...*task) error {
+	frames := make([][]byte, 0, len(tasks))
+	for _, t := range tasks {
+		record, err := newWALRecord(t.act, s.version, s.codec)
+		if err != nil {
+			return err
+		}
+		frame, err := encodeFrame(&spillRecord{walRecord: *record, Seq: t.seq})
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.file == nil {
+		if err := s.create(); err != nil {
+			return err
+		}
+	}
+	// tasks are written to the same segment, and they are recorded only after all of them are written.
+	// a torn frame ends a segment, so nothing is written after it.
+	size := 0
+	for _, frame := range frames {
+		if _, err := s.writer.Write(frame); err != nil {
+			s.closeActive()
+			if active := s.segments[len(s.segments)-1]; len(active.results) == 0 {
+				os.Remove(active.path)
+				s.segments = s.segments[:len(s.segments)-1]
+			}
+			return err
+		}
+		size += len(frame)
+	}
+
+	active := s.segments[len(s.segments)-1]
+	for _, t := range tasks {
+		active.results = append(active.results, t.result)
+	}
+	s.size += int64(size)
+	atomic.AddInt64(&s.pending, int64(len(tasks)))
+	if s.size >= s.segmentSize {
+		if err := s.closeActive(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take reads tasks in the oldest segment and removes it, and it returns nothing if no segment remains.
+// tasks which could not be read back are resolved with an error, and they remain in a write-ahead log if it is enabled.
+func (s *spill) take() ([]*task, error) {
+	s.Lock()
+	if len(s.segments) == 0 {
+		s.Unlock()
+		return nil, nil
+	}
+	segment := s.segments[0]
+	if len(s.segments) == 1 && s.file != nil {
+		if err := s.closeActive(); err != nil {
+			s.Unlock()
+			return nil, err
+		}
+	}
+	s.segments = s.segments[1:]
+	s.Unlock()
+
+	tasks := make([]*task, 0, len(segment.results))
+	err := readFrames(segment.path, func(payload []byte) bool {
+		if len(tasks) >= len(segment.results) {
+			return false
+		}
+		record := &spillRecord{}
+		if err := json.Unmarshal(payload, record); err != nil {
+			return false
+		}
+		act, err := record.action()
+		if err != nil {
+			return false
+		}
+		tasks = append(tasks, &task{act: act, seq: record.Seq, result: segment.results[len(tasks)]})
+		return len(tasks) < len(segment.results)
+	})
+	os.Remove(segment.path)
+
+	if lost := len(segment.results) - len(tasks); lost > 0 {
+		atomic.AddInt64(&s.pending, -int64(lost))
+		for _, result := range segment.results[len(tasks):] {
+			result.resolve(nil, fmt.Errorf("[err] spill (lost)"))
+		}
+		if err == nil {
+			err = fmt.Errorf("[err] spill %d actions lost", lost)
+		}
+	}
+	return tasks, err
+}
+
+// restored records that tasks taken from spill files are pushed to a queue.
+func (s *spill) restored(n int) {
+	atomic.AddInt64(&s.pending, -int64(n))
+}
+
+// len returns a count of tasks spilled and not restored to a queue yet.
+func (s *spill) len() int {
+	if s == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&s.pending))
+}
+
+// create makes a new active segment.
+func (s *spill) create() error {
+	s.nextID++
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.nextID, spillSegmentExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	s.size = 0
+	s.segments = append(s.segments, &spillSegment{path: path})
+	return nil
+}
+
+// closeActive flushes an active segment and closes it.
+func (s *spill) closeActive() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	s.writer = nil
+	return err
+}
+
+// removeAll closes an active segment, and removes every spill file in a directory.
+func (s *spill) removeAll() error {
+	s.closeActive()
+	s.segments = nil
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+spillSegmentExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// createSpill is to make spill.
+func createSpill(cfg *config) (*spill, error) {
+	if cfg == nil || cfg.overflowSpillDir == "" {
+		return nil, fmt.Errorf("[err] createSpill empty params")
+	}
+	return &spill{
+		version:     cfg.version,
+		codec:       getCodec(cfg.codec),
+		dir:         cfg.overflowSpillDir,
+		segmentSize: defaultSpillSegmentSize,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package esworker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func spillSegments(dir string) []string {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+spillSegmentExt))
+	return paths
+}
+
+func TestCreateSpill(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := createSpill(nil)
+	assert.Error(err)
+
+	_, err = createSpill(&config{})
+	assert.Error(err)
+
+	s, err := createSpill(&config{overflowSpillDir: "dir"})
+	assert.NoError(err)
+	assert.Equal(defaultSpillSegmentSize, s.segmentSize)
+	assert.Equal(defaultCodec, s.codec)
+}
+
+func TestSpill(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-spill")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// files remained from a previous run are removed.
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "00000000000000000001"+spillSegmentExt), []byte("stale"), 0644))
+	s, err := createSpill(&config{overflowSpillDir: dir})
+	assert.NoError(err)
+	s.segmentSize = 100
+	assert.NoError(s.open())
+	assert.Len(spillSegments(dir), 0)
+
+	tasks, err := s.take()
+	assert.NoError(err)
+	assert.Len(tasks, 0)
+
+	// tasks are given back in order with their results and sequences.
+	results := make([]*ActionResult, 5)
+	for i := range results {
+		results[i] = newActionResult()
+		act := &StandardAction{Op: ES_INDEX, Index: "allan", Id: fmt.Sprintf("%d", i), Doc: map[string]interface{}{"field": i}}
+		assert.NoError(s.push(&task{act: act, seq: uint64(i + 1), result: results[i]}))
+	}
+	assert.Equal(5, s.len())
+	assert.Len(spillSegments(dir), 3)
+	select {
+	case <-s.notify:
+	default:
+		assert.Fail("not notified")
+	}
+
+	var taken []*task
+	for {
+		tasks, err := s.take()
+		assert.NoError(err)
+		if len(tasks) == 0 {
+			break
+		}
+		taken = append(taken, tasks...)
+	}
+	assert.Len(taken, 5)
+	for i, t := range taken {
+		assert.Equal(fmt.Sprintf("%d", i), t.act.GetID())
+		assert.Equal(fmt.Sprintf(`{"field":%d}`, i), string(t.act.(*RawAction).Doc))
+		assert.Equal(uint64(i+1), t.seq)
+		assert.Equal(results[i], t.result)
+	}
+	assert.Len(spillSegments(dir), 0)
+	s.restored(5)
+	assert.Equal(0, s.len())
+
+	// tasks which could not be read back are resolved with an error.
+	s.segmentSize = defaultSpillSegmentSize
+	result := newActionResult()
+	assert.NoError(s.push(&task{act: &StandardAction{Op: ES_DELETE, Index: "allan", Id: "1"}}))
+	assert.NoError(s.push(&task{act: &StandardAction{Op: ES_DELETE, Index: "allan", Id: "2"}, result: result}))
+	s.Lock()
+	s.closeActive()
+	s.Unlock()
+	path := spillSegments(dir)[0]
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(path, data[:len(data)-3], 0644))
+
+	tasks, err = s.take()
+	assert.Error(err)
+	assert.Len(tasks, 1)
+	assert.Equal(1, s.len())
+	_, err = result.Wait(context.Background())
+	assert.Error(err)
+
+	assert.NoError(s.push(&task{act: &StandardAction{Op: ES_DELETE, Index: "allan", Id: "3"}}))
+	assert.NoError(s.close())
+	assert.Len(spillSegments(dir), 0)
+}
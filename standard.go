@@ -7,6 +7,7 @@ type StandardAction struct {
 	DocType string
 	Id      string
 	Doc     map[string]interface{}
+	Meta    *ActionMeta // optional metadata such as routing and version.
 }
 
 // GetOperation returns an operation to process a document.
@@ -33,3 +34,8 @@ func (da *StandardAction) GetID() string {
 func (da *StandardAction) GetDoc() map[string]interface{} {
 	return da.Doc
 }
+
+// GetMeta returns metadata of a bulk request.
+func (da *StandardAction) GetMeta() *ActionMeta {
+	return da.Meta
+}
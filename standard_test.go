@@ -35,3 +35,9 @@ func TestStandardAction_GetDoc(t *testing.T) {
 	da := &StandardAction{Doc: map[string]interface{}{"allan": "hi"}}
 	assert.Equal("hi", da.GetDoc()["allan"].(string))
 }
+
+func TestStandardAction_GetMeta(t *testing.T) {
+	assert := assert.New(t)
+	da := &StandardAction{Meta: &ActionMeta{Routing: "user1"}}
+	assert.Equal("user1", da.GetMeta().Routing)
+}
@@ -0,0 +1,247 @@
+package esworker
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	bulkRequestErrorType = "bulk_request_exception"
+	encodeErrorType      = "encode_exception"
+)
+
+var (
+	defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+type (
+	// Stats is a snapshot of counters and gauges of a dispatcher.
+	Stats struct {
+		Accepted         int64                 // actions accepted by AddAction.
+		Rejected         int64                 // actions rejected by AddAction.
+		QueueDepth       int                   // actions waiting in a global queue.
+		SpillDepth       int                   // actions waiting in spill files.
+		WorkerQueueDepth []int                 // actions waiting in each worker queue.
+		Bulks            int64                 // bulk requests sent.
+		BulkFailures     int64                 // bulk requests failed as a whole.
+		BytesSent        int64                 // bytes of bulk bodies which got a response (before compression).
+		Retries          int64                 // items sent again.
+		Coalesced        int64                 // actions folded into a queued action on the same document.
+		Dropped          int64                 // actions dropped by an overflow policy.
+		Items            map[ItemStatKey]int64 // items finished by operation, status, error type and index of failed ones.
+		BulkLatency      Histogram             // latency of bulk requests in seconds.
+	}
+
+	// ItemStatKey identifies a kind of finished items.
+	// Status is 0 and ErrorType is bulk_request_exception if a whole bulk request is failed.
+	// Index is only kept for failed items, so time-based indices do not grow keys on every success.
+	ItemStatKey struct {
+		Index     string
+		Operation string
+		Status    int
+		ErrorType string
+	}
+
+	// Histogram is a snapshot of observations.
+	// Counts[i] is the cumulative count of observations less than or equal to Buckets[i].
+	Histogram struct {
+		Buckets []float64
+		Counts  []uint64
+		Count   uint64
+		Sum     float64
+	}
+
+	// metrics collects counters of a dispatcher.
+	metrics struct {
+		accepted     int64
+		rejected     int64
+		bulks        int64
+		bulkFailures int64
+		bytesSent    int64
+		retries      int64
+		coalesced    int64
+		dropped      int64
+		latency      *histogram
+
+		sync.Mutex
+		items map[ItemStatKey]int64
+	}
+
+	// histogram is a concurrent histogram.
+	histogram struct {
+		buckets []float64
+		counts  []uint64
+		count   uint64
+		sum     int64 // nanoseconds
+	}
+)
+
+// Succeeded returns a count of items finished successfully.
+func (s *Stats) Succeeded() int64 {
+	var total int64
+	for key, count := range s.Items {
+		if key.Status > 0 && key.Status <= 299 {
+			total += count
+		}
+	}
+	return total
+}
+
+// Failed returns a count of items finished with failure.
+func (s *Stats) Failed() int64 {
+	var total int64
+	for key, count := range s.Items {
+		if key.Status == 0 || key.Status > 299 {
+			total += count
+		}
+	}
+	return total
+}
+
+// observeBulk records a bulk request.
+func (m *metrics) observeBulk(d time.Duration, bytes int, err error) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.bulks, 1)
+	atomic.AddInt64(&m.bytesSent, int64(bytes))
+	if err != nil {
+		atomic.AddInt64(&m.bulkFailures, 1)
+	}
+	m.latency.observe(d)
+}
+
+// observeItem records a finished item.
+func (m *metrics) observeItem(act Action, status *ESResponseStatus, err error) {
+	if m == nil {
+		return
+	}
+	key := ItemStatKey{Operation: act.GetOperation().GetString()}
+	if err != nil {
+		key.Index = act.GetIndex()
+	}
+	if status != nil {
+		key.Status = status.Status
+		if err != nil {
+			key.ErrorType = status.Error.Type
+		}
+	} else if err != nil {
+		key.ErrorType = bulkRequestErrorType
+	}
+
+	m.Lock()
+	m.items[key]++
+	m.Unlock()
+}
+
+// observeAdd records a result of AddAction.
+func (m *metrics) observeAdd(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&m.rejected, 1)
+	} else {
+		atomic.AddInt64(&m.accepted, 1)
+	}
+}
+
+// observeAdds records a result of AddActions.
+func (m *metrics) observeAdds(accepted, rejected int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.accepted, int64(accepted))
+	atomic.AddInt64(&m.rejected, int64(rejected))
+}
+
+// observeRetry records items sent again.
+func (m *metrics) observeRetry(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.retries, int64(n))
+}
+
+// observeCoalesce records actions folded into queued actions.
+func (m *metrics) observeCoalesce(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.coalesced, int64(n))
+}
+
+// observeDrop records actions dropped by an overflow policy.
+func (m *metrics) observeDrop(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.dropped, int64(n))
+}
+
+// snapshot copies counters to Stats.
+func (m *metrics) snapshot() *Stats {
+	stats := &Stats{
+		Accepted:     atomic.LoadInt64(&m.accepted),
+		Rejected:     atomic.LoadInt64(&m.rejected),
+		Bulks:        atomic.LoadInt64(&m.bulks),
+		BulkFailures: atomic.LoadInt64(&m.bulkFailures),
+		BytesSent:    atomic.LoadInt64(&m.bytesSent),
+		Retries:      atomic.LoadInt64(&m.retries),
+		Coalesced:    atomic.LoadInt64(&m.coalesced),
+		Dropped:      atomic.LoadInt64(&m.dropped),
+		BulkLatency:  m.latency.snapshot(),
+	}
+
+	m.Lock()
+	stats.Items = make(map[ItemStatKey]int64, len(m.items))
+	for key, count := range m.items {
+		stats.Items[key] = count
+	}
+	m.Unlock()
+	return stats
+}
+
+// observe records a duration.
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, seconds)
+	if i < len(h.buckets) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// snapshot copies observations to Histogram with cumulative counts.
+func (h *histogram) snapshot() Histogram {
+	snap := Histogram{
+		Buckets: append([]float64{}, h.buckets...),
+		Counts:  make([]uint64, len(h.buckets)),
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)).Seconds(),
+	}
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		snap.Counts[i] = cumulative
+	}
+	return snap
+}
+
+// newHistogram is to make histogram with upper bounds of buckets.
+func newHistogram(buckets []float64) *histogram {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	return &histogram{buckets: b, counts: make([]uint64, len(b))}
+}
+
+// newMetrics is to make metrics.
+func newMetrics() *metrics {
+	return &metrics{
+		latency: newHistogram(defaultLatencyBuckets),
+		items:   make(map[ItemStatKey]int64),
+	}
+}
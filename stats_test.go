@@ -0,0 +1,75 @@
+package esworker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newHistogram([]float64{1, 0.1, 0.5})
+	h.observe(50 * time.Millisecond)
+	h.observe(100 * time.Millisecond)
+	h.observe(300 * time.Millisecond)
+	h.observe(2 * time.Second)
+
+	snap := h.snapshot()
+	assert.Equal([]float64{0.1, 0.5, 1}, snap.Buckets)
+	assert.Equal([]uint64{2, 3, 3}, snap.Counts)
+	assert.Equal(uint64(4), snap.Count)
+	assert.InDelta(2.45, snap.Sum, 0.0001)
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	var empty *metrics
+	empty.observeAdd(nil)
+	empty.observeAdds(1, 1)
+	empty.observeRetry(1)
+	empty.observeCoalesce(1)
+	empty.observeDrop(1)
+	empty.observeBulk(time.Second, 1, nil)
+	empty.observeItem(&mockAction{}, nil, nil)
+
+	m := newMetrics()
+	m.observeAdd(nil)
+	m.observeAdd(nil)
+	m.observeAdd(fmt.Errorf("error"))
+	m.observeAdds(2, 1)
+	m.observeRetry(3)
+	m.observeCoalesce(2)
+	m.observeDrop(4)
+	m.observeBulk(10*time.Millisecond, 100, nil)
+	m.observeBulk(20*time.Millisecond, 0, fmt.Errorf("error"))
+
+	act := &mockAction{op: ES_INDEX, index: "allan"}
+	m.observeItem(act, &ESResponseStatus{Status: 201}, nil)
+	m.observeItem(act, &ESResponseStatus{Status: 201}, nil)
+	m.observeItem(act, &ESResponseStatus{Status: 400, Error: ESResponseError{Type: "mapper_parsing_exception"}}, fmt.Errorf("error"))
+	m.observeItem(act, nil, fmt.Errorf("error"))
+
+	stats := m.snapshot()
+	assert.Equal(int64(4), stats.Accepted)
+	assert.Equal(int64(2), stats.Rejected)
+	assert.Equal(int64(3), stats.Retries)
+	assert.Equal(int64(2), stats.Coalesced)
+	assert.Equal(int64(4), stats.Dropped)
+	assert.Equal(int64(2), stats.Bulks)
+	assert.Equal(int64(1), stats.BulkFailures)
+	assert.Equal(int64(100), stats.BytesSent)
+	assert.Equal(uint64(2), stats.BulkLatency.Count)
+	assert.Equal(int64(2), stats.Items[ItemStatKey{Operation: "index", Status: 201}])
+	assert.Equal(int64(1), stats.Items[ItemStatKey{Index: "allan", Operation: "index", Status: 400, ErrorType: "mapper_parsing_exception"}])
+	assert.Equal(int64(1), stats.Items[ItemStatKey{Index: "allan", Operation: "index", ErrorType: bulkRequestErrorType}])
+	assert.Equal(int64(2), stats.Succeeded())
+	assert.Equal(int64(2), stats.Failed())
+
+	// a snapshot is not changed after taken.
+	m.observeItem(act, &ESResponseStatus{Status: 201}, nil)
+	assert.Equal(int64(2), stats.Succeeded())
+}
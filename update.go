@@ -0,0 +1,95 @@
+package esworker
+
+// Script is an inline script to update a document.
+type Script struct {
+	Source string
+	Lang   string // painless if empty.
+	Params map[string]interface{}
+}
+
+// UpdateAction is a struct to implement an interface of Action, which partially updates a document with a doc or a script.
+type UpdateAction struct {
+	Index           string
+	DocType         string
+	Id              string
+	Doc             map[string]interface{} // partial document merged into an existing one.
+	Script          *Script                // script to update a document instead of Doc.
+	Upsert          map[string]interface{} // document indexed if it doesn't exist.
+	DocAsUpsert     bool                   // Doc is indexed if a document doesn't exist.
+	ScriptedUpsert  bool                   // Script runs whether or not a document exists.
+	RetryOnConflict int                    // how many times the elasticsearch retries on version conflicts.
+	Meta            *ActionMeta            // optional metadata such as routing.
+}
+
+// GetOperation returns ES_UPDATE.
+func (ua *UpdateAction) GetOperation() ESOperation {
+	return ES_UPDATE
+}
+
+// GetIndex returns an index of a document.
+func (ua *UpdateAction) GetIndex() string {
+	return ua.Index
+}
+
+// GetDocType returns a doctype of a document.
+func (ua *UpdateAction) GetDocType() string {
+	return ua.DocType
+}
+
+// GetID returns an id of a document.
+func (ua *UpdateAction) GetID() string {
+	return ua.Id
+}
+
+// GetDoc returns a body of the update request.
+func (ua *UpdateAction) GetDoc() map[string]interface{} {
+	return ua.body(V6)
+}
+
+// body returns a body of the update request on the version.
+// a script is given as inline at v5, because source is only accepted since v5.6.
+func (ua *UpdateAction) body(v ESVersion) map[string]interface{} {
+	body := map[string]interface{}{}
+	if ua.Doc != nil {
+		body["doc"] = ua.Doc
+	}
+	if ua.Script != nil {
+		sourceKey := "source"
+		if v == V5 {
+			sourceKey = "inline"
+		}
+		script := map[string]interface{}{sourceKey: ua.Script.Source}
+		if ua.Script.Lang != "" {
+			script["lang"] = ua.Script.Lang
+		}
+		if len(ua.Script.Params) > 0 {
+			script["params"] = ua.Script.Params
+		}
+		body["script"] = script
+	}
+	if ua.Upsert != nil {
+		body["upsert"] = ua.Upsert
+	}
+	if ua.DocAsUpsert {
+		body["doc_as_upsert"] = true
+	}
+	if ua.ScriptedUpsert {
+		body["scripted_upsert"] = true
+	}
+	return body
+}
+
+// GetMeta returns metadata including RetryOnConflict.
+func (ua *UpdateAction) GetMeta() *ActionMeta {
+	if ua.Meta == nil && ua.RetryOnConflict == 0 {
+		return nil
+	}
+	meta := &ActionMeta{}
+	if ua.Meta != nil {
+		*meta = *ua.Meta
+	}
+	if ua.RetryOnConflict > 0 {
+		meta.RetryOnConflict = ua.RetryOnConflict
+	}
+	return meta
+}
@@ -0,0 +1,68 @@
+package esworker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateAction_GetDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input  *UpdateAction
+		output string
+	}{
+		"doc": {
+			input:  &UpdateAction{Doc: map[string]interface{}{"a": 1}},
+			output: `{"doc":{"a":1}}`,
+		},
+		"doc_as_upsert": {
+			input:  &UpdateAction{Doc: map[string]interface{}{"a": 1}, DocAsUpsert: true},
+			output: `{"doc":{"a":1},"doc_as_upsert":true}`,
+		},
+		"script": {
+			input: &UpdateAction{
+				Script: &Script{Source: "ctx._source.a += params.n", Lang: "painless", Params: map[string]interface{}{"n": 2}},
+				Upsert: map[string]interface{}{"a": 0},
+			},
+			output: `{"script":{"lang":"painless","params":{"n":2},"source":"ctx._source.a += params.n"},"upsert":{"a":0}}`,
+		},
+		"scripted_upsert": {
+			input:  &UpdateAction{Script: &Script{Source: "ctx._source.a = 1"}, Upsert: map[string]interface{}{}, ScriptedUpsert: true},
+			output: `{"script":{"source":"ctx._source.a = 1"},"scripted_upsert":true,"upsert":{}}`,
+		},
+	}
+
+	for _, t := range tests {
+		body, err := json.Marshal(t.input.GetDoc())
+		assert.NoError(err)
+		assert.Equal(t.output, string(body))
+		assert.Equal(ES_UPDATE, t.input.GetOperation())
+	}
+
+	// a script is given as inline at v5.
+	body, err := json.Marshal((&UpdateAction{Script: &Script{Source: "ctx._source.a = 1"}}).body(V5))
+	assert.NoError(err)
+	assert.Equal(`{"script":{"inline":"ctx._source.a = 1"}}`, string(body))
+
+	act := &UpdateAction{Index: "allan", DocType: "_doc", Id: "1"}
+	assert.Equal("allan", act.GetIndex())
+	assert.Equal("_doc", act.GetDocType())
+	assert.Equal("1", act.GetID())
+}
+
+func TestUpdateAction_GetMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	act := &UpdateAction{Index: "allan", Id: "1"}
+	assert.Nil(act.GetMeta())
+
+	act.RetryOnConflict = 3
+	assert.Equal(&ActionMeta{RetryOnConflict: 3}, act.GetMeta())
+
+	act.Meta = &ActionMeta{Routing: "user1", RetryOnConflict: 1}
+	assert.Equal(&ActionMeta{Routing: "user1", RetryOnConflict: 3}, act.GetMeta())
+	assert.Equal(1, act.Meta.RetryOnConflict)
+}
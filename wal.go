@@ -0,0 +1,524 @@
+package esworker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WALSyncPolicy decides when a write-ahead log is flushed to a disk.
+type WALSyncPolicy int
+
+const (
+	WAL_SYNC_ALWAYS   WALSyncPolicy = iota // fsync whenever an action is appended.
+	WAL_SYNC_INTERVAL                      // fsync periodically.
+	WAL_SYNC_NONE                          // leave it to the operating system.
+)
+
+const (
+	walSegmentExt    = ".wal"
+	walAckExt        = ".ack"
+	walHeaderSize    = 8
+	walMaxRecordSize = 256 * 1024 * 1024
+)
+
+var (
+	defaultWALSegmentSize  = int64(64 * 1024 * 1024)
+	defaultWALSyncInterval = time.Duration(time.Second)
+)
+
+// walRecord is an action stored in a write-ahead log.
+type walRecord struct {
+	Op      string          `json:"op"`
+	Index   string          `json:"index"`
+	DocType string          `json:"type,omitempty"`
+	Id      string          `json:"id,omitempty"`
+	Doc     json.RawMessage `json:"doc,omitempty"`
+	Meta    *ActionMeta     `json:"meta,omitempty"`
+}
+
+// walSegment is a file of a write-ahead log, which holds records from firstSeq to lastSeq.
+type walSegment struct {
+	path     string
+	firstSeq uint64
+	lastSeq  uint64
+	pending  int           // count of records which are not acknowledged.
+	acks     *os.File      // a file of acknowledged sequences, which is opened at the first ack.
+	ackBuf   *bufio.Writer // a writer of acks, which is flushed when records are synced.
+}
+
+// wal is a write-ahead log which keeps actions on a local disk until they are delivered.
+// every record is framed with its length and crc32 checksum, and a segment file is removed
+// when all of records in it are acknowledged.
+// acknowledged sequences of a segment are kept in its ack file, so they are not replayed.
+type wal struct {
+	sync.Mutex
+	version      ESVersion // a document is stored as it is sent on the version.
+	codec        Codec     // a codec serializing documents.
+	dir          string
+	segmentSize  int64
+	syncPolicy   WALSyncPolicy
+	syncInterval time.Duration
+	segments     []*walSegment // the last one is an active segment.
+	stale        []string      // segment files which are replayed, but not removed yet.
+	file         *os.File
+	writer       *bufio.Writer
+	size         int64
+	nextSeq      uint64
+	dirty        bool
+	quit         chan bool
+}
+
+// open reads actions remained in segment files and prepares a new active segment.
+// actions returned should be appended again, and then removeStale is called.
+func (l *wal) open() ([]Action, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.file != nil {
+		return nil, fmt.Errorf("[err] wal already opened")
+	}
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*"+walSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var acts []Action
+	var replayed []string
+	l.nextSeq = 1
+	for _, path := range paths {
+		// a file which is not named by a sequence is not a segment, so it is left as it is.
+		var firstSeq uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(filepath.Base(path), walSegmentExt), "%d", &firstSeq); err != nil {
+			continue
+		}
+		records, err := readWALSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		acked, err := readWALAcks(path + walAckExt)
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if acked[firstSeq+uint64(i)] {
+				continue
+			}
+			act, err := record.action()
+			if err != nil {
+				return nil, err
+			}
+			acts = append(acts, act)
+		}
+		// a new segment must not take a name of stale ones.
+		seq := firstSeq + uint64(len(records))
+		if seq <= firstSeq {
+			seq = firstSeq + 1
+		}
+		if seq > l.nextSeq {
+			l.nextSeq = seq
+		}
+		replayed = append(replayed, path)
+	}
+	l.stale = replayed
+
+	if err := l.rotate(); err != nil {
+		return nil, err
+	}
+
+	if l.syncPolicy == WAL_SYNC_INTERVAL {
+		l.quit = make(chan bool)
+		go l.syncLoop(l.quit)
+	}
+	return acts, nil
+}
+
+// removeStale removes segment files which have been replayed.
+func (l *wal) removeStale() error {
+	l.Lock()
+	defer l.Unlock()
+	for _, path := range l.stale {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(path + walAckExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	l.stale = nil
+	return nil
+}
+
+// append writes an action to an active segment, and returns its sequence.
+func (l *wal) append(act Action) (uint64, error) {
+	record, err := newWALRecord(act, l.version, l.codec)
+	if err != nil {
+		return 0, err
+	}
+	frame, err := encodeFrame(record)
+	if err != nil {
+		return 0, err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.file == nil {
+		return 0, fmt.Errorf("[err] wal not opened")
+	}
+
+	if _, err := l.writer.Write(frame); err != nil {
+		return 0, err
+	}
+
+	seq := l.nextSeq
+	l.nextSeq++
+	l.size += int64(len(frame))
+	active := l.segments[len(l.segments)-1]
+	active.lastSeq = seq
+	active.pending++
+
+	switch l.syncPolicy {
+	case WAL_SYNC_ALWAYS:
+		if err := l.sync(); err != nil {
+			return 0, err
+		}
+	default:
+		if err := l.writer.Flush(); err != nil {
+			return 0, err
+		}
+		l.dirty = true
+	}
+
+	if l.size >= l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// ack acknowledges that an action of the sequence has been dealt with.
+func (l *wal) ack(seq uint64) {
+	if l == nil || seq == 0 {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].lastSeq >= seq
+	})
+	if i == len(l.segments) || l.segments[i].firstSeq > seq {
+		return
+	}
+
+	segment := l.segments[i]
+	segment.pending--
+	// an active segment is removed when it is rotated.
+	if segment.pending <= 0 && i != len(l.segments)-1 {
+		segment.remove()
+		l.segments = append(l.segments[:i], l.segments[i+1:]...)
+		return
+	}
+
+	// an ack is recorded while its segment remains, and it could be lost when a process crashed before syncing.
+	if err := segment.writeAck(seq); err != nil {
+		return
+	}
+	if l.syncPolicy == WAL_SYNC_ALWAYS {
+		segment.ackBuf.Flush()
+	} else {
+		l.dirty = true
+	}
+}
+
+// close flushes an active segment and closes it.
+// segment files are removed if all of records are acknowledged.
+func (l *wal) close() error {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	if l.quit != nil {
+		close(l.quit)
+		l.quit = nil
+	}
+
+	if err := l.sync(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	l.writer = nil
+
+	for _, segment := range l.segments {
+		if segment.pending <= 0 {
+			segment.remove()
+		} else if err := segment.closeAcks(); err != nil {
+			return err
+		}
+	}
+	l.segments = nil
+	return nil
+}
+
+// rotate closes an active segment and creates a new one.
+func (l *wal) rotate() error {
+	if l.file != nil {
+		if err := l.sync(); err != nil {
+			return err
+		}
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+		// remove the segment if all of records are already acknowledged.
+		last := len(l.segments) - 1
+		if l.segments[last].pending <= 0 {
+			l.segments[last].remove()
+			l.segments = l.segments[:last]
+		}
+	}
+
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.nextSeq, walSegmentExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = 0
+	l.segments = append(l.segments, &walSegment{path: path, firstSeq: l.nextSeq, lastSeq: l.nextSeq - 1})
+	return nil
+}
+
+// sync flushes buffered records and acks, and commits records to a disk.
+func (l *wal) sync() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	for _, segment := range l.segments {
+		if segment.ackBuf != nil {
+			if err := segment.ackBuf.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if l.syncPolicy == WAL_SYNC_NONE {
+		return nil
+	}
+	l.dirty = false
+	return l.file.Sync()
+}
+
+// syncLoop commits records to a disk on every sync interval.
+func (l *wal) syncLoop(quit chan bool) {
+	ticker := time.NewTicker(l.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Lock()
+			if l.file != nil && l.dirty {
+				l.sync()
+			}
+			l.Unlock()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// writeAck records an acknowledged sequence to an ack file of the segment.
+func (segment *walSegment) writeAck(seq uint64) error {
+	if segment.acks == nil {
+		file, err := os.OpenFile(segment.path+walAckExt, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		segment.acks = file
+		segment.ackBuf = bufio.NewWriter(file)
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	_, err := segment.ackBuf.Write(b[:])
+	return err
+}
+
+// closeAcks flushes an ack file of the segment and closes it.
+func (segment *walSegment) closeAcks() error {
+	if segment.acks == nil {
+		return nil
+	}
+	err := segment.ackBuf.Flush()
+	if cerr := segment.acks.Close(); err == nil {
+		err = cerr
+	}
+	segment.acks = nil
+	segment.ackBuf = nil
+	return err
+}
+
+// remove removes the segment file and its ack file.
+func (segment *walSegment) remove() {
+	segment.closeAcks()
+	os.Remove(segment.path)
+	os.Remove(segment.path + walAckExt)
+}
+
+// action restores an action from the record, and its document is replayed as it is stored.
+func (record *walRecord) action() (Action, error) {
+	op, ok := parseESOperation(record.Op)
+	if !ok {
+		return nil, fmt.Errorf("[err] wal unknown operation %s", record.Op)
+	}
+	return &RawAction{
+		Op:      op,
+		Index:   record.Index,
+		DocType: record.DocType,
+		Id:      record.Id,
+		Doc:     record.Doc,
+		Meta:    record.Meta,
+	}, nil
+}
+
+// newWALRecord makes a record of an action, and its document is stored as it is sent on the version.
+func newWALRecord(act Action, v ESVersion, codec Codec) (*walRecord, error) {
+	doc, err := encodeDoc(act, v, codec)
+	if err != nil {
+		return nil, err
+	}
+	return &walRecord{
+		Op:      act.GetOperation().GetString(),
+		Index:   act.GetIndex(),
+		DocType: act.GetDocType(),
+		Id:      act.GetID(),
+		Doc:     doc,
+		Meta:    getActionMeta(act),
+	}, nil
+}
+
+// readWALSegment reads records in a segment file.
+func readWALSegment(path string) ([]*walRecord, error) {
+	var records []*walRecord
+	err := readFrames(path, func(payload []byte) bool {
+		record := &walRecord{}
+		if err := json.Unmarshal(payload, record); err != nil {
+			return false
+		}
+		records = append(records, record)
+		return true
+	})
+	return records, err
+}
+
+// readWALAcks reads acknowledged sequences in an ack file, and a torn sequence at the end is ignored.
+func readWALAcks(path string) (map[uint64]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	acked := make(map[uint64]bool, len(data)/8)
+	for ; len(data) >= 8; data = data[8:] {
+		acked[binary.BigEndian.Uint64(data[:8])] = true
+	}
+	return acked, nil
+}
+
+// encodeFrame serializes v to JSON framed with its length and crc32 checksum.
+func encodeFrame(v interface{}) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:walHeaderSize], crc32.ChecksumIEEE(payload))
+	copy(frame[walHeaderSize:], payload)
+	return frame, nil
+}
+
+// readFrames reads framed payloads in a file, and calls fn with each of them until it returns false.
+// reading stops at a torn or corrupted frame which could be written when a process crashed.
+func readFrames(path string, fn func(payload []byte) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, walHeaderSize)
+	r := bufio.NewReader(f)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		if size > walMaxRecordSize {
+			return nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return nil
+		}
+		if !fn(payload) {
+			return nil
+		}
+	}
+}
+
+// createWAL is to make wal.
+func createWAL(cfg *config) (*wal, error) {
+	if cfg == nil || cfg.walDir == "" {
+		return nil, fmt.Errorf("[err] createWAL empty params")
+	}
+
+	segmentSize := cfg.walSegmentSize
+	if segmentSize <= 0 {
+		segmentSize = defaultWALSegmentSize
+	}
+	syncInterval := cfg.walSyncInterval
+	if syncInterval <= 0 {
+		syncInterval = defaultWALSyncInterval
+	}
+
+	return &wal{
+		version:      cfg.version,
+		codec:        getCodec(cfg.codec),
+		dir:          cfg.walDir,
+		segmentSize:  segmentSize,
+		syncPolicy:   cfg.walSyncPolicy,
+		syncInterval: syncInterval,
+	}, nil
+}
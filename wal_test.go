@@ -0,0 +1,253 @@
+package esworker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testWAL(t *testing.T, dir string, segmentSize int64) *wal {
+	l, err := createWAL(&config{walDir: dir, walSegmentSize: segmentSize, walSyncPolicy: WAL_SYNC_ALWAYS})
+	assert.NoError(t, err)
+	return l
+}
+
+func walSegments(dir string) []string {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+walSegmentExt))
+	return paths
+}
+
+func TestCreateWAL(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := createWAL(nil)
+	assert.Error(err)
+
+	_, err = createWAL(&config{})
+	assert.Error(err)
+
+	l, err := createWAL(&config{walDir: "dir"})
+	assert.NoError(err)
+	assert.Equal(defaultWALSegmentSize, l.segmentSize)
+	assert.Equal(defaultWALSyncInterval, l.syncInterval)
+}
+
+func TestWAL_Replay(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	l := testWAL(t, dir, 1024*1024)
+	_, err = l.append(&mockAction{op: ES_INDEX, index: "allan"})
+	assert.Error(err)
+
+	acts, err := l.open()
+	assert.NoError(err)
+	assert.Len(acts, 0)
+	_, err = l.open()
+	assert.Error(err)
+
+	var seqs []uint64
+	for i := 0; i < 5; i++ {
+		seq, err := l.append(&StandardAction{
+			Op:    ES_INDEX,
+			Index: "allan",
+			Id:    fmt.Sprintf("%d", i),
+			Doc:   map[string]interface{}{"field": fmt.Sprintf("%d", i)},
+			Meta:  &ActionMeta{Routing: fmt.Sprintf("r%d", i)},
+		})
+		assert.NoError(err)
+		seqs = append(seqs, seq)
+	}
+	assert.Equal([]uint64{1, 2, 3, 4, 5}, seqs)
+	l.ack(2)
+	l.ack(4)
+
+	// crash without close.
+	l.file.Close()
+
+	// a torn record is written at the tail.
+	f, err := os.OpenFile(walSegments(dir)[0], os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(err)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	l = testWAL(t, dir, 1024*1024)
+	acts, err = l.open()
+	assert.NoError(err)
+	// acknowledged records in an unfinished segment are not replayed.
+	assert.Len(acts, 3)
+	assert.Equal("2", acts[1].GetID())
+	assert.Equal("2", acts[1].GetDoc()["field"])
+	assert.Equal("r2", acts[1].(*RawAction).Meta.Routing)
+	assert.Len(walSegments(dir), 2)
+
+	for _, act := range acts {
+		seq, err := l.append(act)
+		assert.NoError(err)
+		assert.True(seq > 5)
+	}
+	assert.NoError(l.removeStale())
+	assert.Len(walSegments(dir), 1)
+	ackFiles, _ := filepath.Glob(filepath.Join(dir, "*"+walAckExt))
+	assert.Len(ackFiles, 0)
+
+	assert.NoError(l.close())
+	assert.Len(walSegments(dir), 1)
+	l = testWAL(t, dir, 1024*1024)
+	acts, err = l.open()
+	assert.NoError(err)
+	assert.Len(acts, 3)
+}
+
+func TestWAL_PartialAck(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	l, err := createWAL(&config{walDir: dir, walSyncPolicy: WAL_SYNC_INTERVAL, walSyncInterval: time.Hour})
+	assert.NoError(err)
+	_, err = l.open()
+	assert.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err := l.append(&StandardAction{Op: ES_INDEX, Index: "allan", Id: fmt.Sprintf("%d", i)})
+		assert.NoError(err)
+	}
+	l.ack(1)
+	l.ack(3)
+	assert.NoError(l.close())
+
+	// only a record which is not acknowledged is replayed.
+	l, err = createWAL(&config{walDir: dir, walSyncPolicy: WAL_SYNC_INTERVAL, walSyncInterval: time.Hour})
+	assert.NoError(err)
+	acts, err := l.open()
+	assert.NoError(err)
+	assert.Len(acts, 1)
+	assert.Equal("1", acts[0].GetID())
+
+	// a torn ack at the end is ignored.
+	ackFiles, _ := filepath.Glob(filepath.Join(dir, "*"+walAckExt))
+	assert.Len(ackFiles, 1)
+	f, err := os.OpenFile(ackFiles[0], os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(err)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+	acked, err := readWALAcks(ackFiles[0])
+	assert.NoError(err)
+	assert.Equal(map[uint64]bool{1: true, 3: true}, acked)
+	assert.NoError(l.close())
+}
+
+func TestWAL_ReplayRaw(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	l := testWAL(t, dir, 1024*1024)
+	_, err = l.open()
+	assert.NoError(err)
+	_, err = l.append(&StandardAction{Op: ES_INDEX, Index: "allan", Id: "1",
+		Doc: map[string]interface{}{"id": uint64(9007199254740993)}})
+	assert.NoError(err)
+	assert.NoError(l.close())
+
+	// a document is replayed as it is stored, and integers above 2^53 are not rounded.
+	l = testWAL(t, dir, 1024*1024)
+	acts, err := l.open()
+	assert.NoError(err)
+	assert.Len(acts, 1)
+	assert.Equal(`{"id":9007199254740993}`, string(acts[0].(RawDocAction).GetRawDoc()))
+	assert.NoError(l.close())
+}
+
+func TestWAL_UnknownFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	unknown := filepath.Join(dir, "backup"+walSegmentExt)
+	assert.NoError(ioutil.WriteFile(unknown, []byte("backup"), 0644))
+
+	// a file which is not named by a sequence is neither replayed nor removed.
+	l := testWAL(t, dir, 1024*1024)
+	acts, err := l.open()
+	assert.NoError(err)
+	assert.Len(acts, 0)
+	assert.NoError(l.removeStale())
+	assert.NoError(l.close())
+	_, err = os.Stat(unknown)
+	assert.NoError(err)
+}
+
+func TestWAL_Ack(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// every segment holds two records.
+	l := testWAL(t, dir, 100)
+	_, err = l.open()
+	assert.NoError(err)
+
+	for i := 0; i < 6; i++ {
+		_, err := l.append(&mockAction{op: ES_INDEX, index: "allan", doc: map[string]interface{}{"field": "abcdefghijklmnopqrstuvwxyz"}})
+		assert.NoError(err)
+	}
+	assert.Len(walSegments(dir), 4)
+
+	// a segment is removed when all of records are acknowledged.
+	l.ack(1)
+	assert.Len(walSegments(dir), 4)
+	l.ack(2)
+	assert.Len(walSegments(dir), 3)
+	l.ack(5)
+	l.ack(6)
+	assert.Len(walSegments(dir), 2)
+
+	// unknown sequences are ignored.
+	l.ack(0)
+	l.ack(100)
+
+	assert.NoError(l.close())
+	assert.Len(walSegments(dir), 1)
+
+	var empty *wal
+	empty.ack(1)
+	assert.NoError(empty.close())
+}
+
+func TestWAL_SyncInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "esworker-wal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	l, err := createWAL(&config{walDir: dir, walSyncPolicy: WAL_SYNC_INTERVAL, walSyncInterval: 10 * time.Millisecond})
+	assert.NoError(err)
+	_, err = l.open()
+	assert.NoError(err)
+
+	_, err = l.append(&mockAction{op: ES_INDEX, index: "allan"})
+	assert.NoError(err)
+	time.Sleep(50 * time.Millisecond)
+	l.Lock()
+	assert.False(l.dirty)
+	l.Unlock()
+	assert.NoError(l.close())
+}
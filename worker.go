@@ -2,23 +2,68 @@ package esworker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	defaultBulkTimeout = time.Duration(1 * time.Minute)
+)
+
 // worker will be processing actions with bulk-operation.
 type worker struct {
 	sync.RWMutex
 	esClient     ESProxy
 	id           int
-	pool         chan chan Action
-	pipe         chan Action
-	queue        []Action
+	pool         chan chan []*task
+	pipe         chan []*task
+	queue        []*task
+	queueBytes   int
+	delayed      int       // count of tasks waiting for a retry in a queue.
+	retryAt      time.Time // when the earliest task waiting for a retry is ready.
 	maxQueueSize int
+	maxBulkBytes int
 	waitInterval time.Duration
 	errorHandler ErrorHandler
+	beforeBulk   BeforeBulkHook
+	afterBulk    AfterBulkHook
+	retry        *retrier
+	adaptive     *batchController // it adjusts a batch size and a wait interval (nil if they are fixed).
+	partition    PartitionFunc    // it keeps an order of actions with the same key (nil if not partitioned).
+	coalesce     bool             // whether an action is folded into a queued action on the same document.
+	pending      map[string]*task // tasks which are not sent yet by their documents, into which an action could be folded.
+	deadLetter   DeadLetterSink
+	wal          *wal
+	flush        chan *flushRequest
 	quit         chan bool
+	ctx          context.Context // it is canceled when in-flight bulks should be aborted.
+	metrics      *metrics
+	succeeded    int64 // count of actions finished successfully.
+	failed       int64 // count of actions finished with failure.
+}
+
+// flushRequest asks workers to process all of actions in their queue.
+type flushRequest struct {
+	ctx     context.Context
+	wg      sync.WaitGroup
+	success int64
+	fail    int64
+}
+
+// task is an action waiting in a queue.
+type task struct {
+	act      Action
+	seq      uint64        // sequence in a write-ahead log (0 if it isn't logged).
+	attempts int           // how many times the action has been sent.
+	result   *ActionResult // it is resolved when the action is finished (nil if nobody waits).
+	lines    []byte        // the action serialized in a bulk body (nil if it is not serialized in advance).
+	size     int           // serialized bytes of the action in a bulk body.
+	readyAt  time.Time     // the action is not sent before it (zero if it is ready).
+	folded   []*task       // tasks folded into the task, which are finished together.
+	absorbed bool          // a delete absorbed an action writing the document, so not_found of it is regarded as success.
 }
 
 // start is to start loop.
@@ -31,27 +76,31 @@ func (w *worker) start() {
 	}()
 
 	// the pipe is inserted to the worker pool in firstly.
-	w.pool <- w.pipe
+	w.ready()
 Loop:
 	for {
 		select {
-		case act := <-w.pipe: // get data.
-			if err := w.enqueue(act); err != nil { // enqueue a action.
-				w.errorHandler(err)
-			}
+		case tasks := <-w.pipe: // get data.
+			w.push(tasks...) // enqueue actions.
 
-			if w.queueSize() >= w.maxQueueSize { // exceed a threshold.
+			if w.queueFull() { // exceed a threshold.
 				if err := w.process(); err != nil { // processing rest jobs
 					w.errorHandler(err)
 				}
 			}
 
 			// ready for getting an action
-			w.pool <- w.pipe
-		case <-time.After(w.waitInterval): // wait duration interval time.
+			w.ready()
+		case <-time.After(w.nextWait()): // wait duration interval time or until a retry is ready.
 			if err := w.process(); err != nil {
 				w.errorHandler(err)
 			}
+		case req := <-w.flush: // process immediately.
+			w.receiveBuffered()
+			success, fail := w.drain(req.ctx)
+			atomic.AddInt64(&req.success, success)
+			atomic.AddInt64(&req.fail, fail)
+			req.wg.Done()
 		case <-w.quit: // exit loop.
 			break Loop
 		}
@@ -62,10 +111,53 @@ Loop:
 // stop is to stop loop
 func (w *worker) stop() {
 	w.quit <- true
-	// processing rest jobs.
-	if err := w.process(); err != nil {
-		w.errorHandler(err)
+	w.receiveBuffered()
+	// processing rest jobs including retries.
+	w.drain(w.context())
+}
+
+// ready inserts the pipe to the worker pool, and nothing is inserted in a partitioning mode.
+// the pool is read under the lock because it is replaced at resizing.
+func (w *worker) ready() {
+	w.RLock()
+	defer w.RUnlock()
+	if w.pool != nil {
+		w.pool <- w.pipe
+	}
+}
+
+// setPool replaces the worker pool, and the pipe is inserted to the new one from the next time.
+func (w *worker) setPool(pool chan chan []*task) {
+	w.Lock()
+	defer w.Unlock()
+	w.pool = pool
+}
+
+// receiveBuffered pushes tasks buffered in the pipe to a queue.
+func (w *worker) receiveBuffered() {
+	for n := len(w.pipe); n > 0; n-- {
+		w.push(<-w.pipe...)
+	}
+}
+
+// drain processes actions in a queue including retries until it is empty or ctx is done.
+// it returns how many actions are finished during draining.
+func (w *worker) drain(ctx context.Context) (success int64, fail int64) {
+	succeeded, failed := atomic.LoadInt64(&w.succeeded), atomic.LoadInt64(&w.failed)
+	for w.queueSize() > 0 && ctx.Err() == nil {
+		// wait until tasks waiting for a retry are ready.
+		if delay := w.readyDelay(); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				continue
+			}
+		}
+		if err := w.process(); err != nil {
+			w.errorHandler(err)
+		}
 	}
+	return atomic.LoadInt64(&w.succeeded) - succeeded, atomic.LoadInt64(&w.failed) - failed
 }
 
 // enqueue adds action to a queue.
@@ -73,18 +165,298 @@ func (w *worker) enqueue(act ...Action) error {
 	if len(act) == 0 {
 		return fmt.Errorf("[err] enqueue (empty params)")
 	}
+
+	tasks := make([]*task, 0, len(act))
+	for _, a := range act {
+		tasks = append(tasks, &task{act: a})
+	}
+	w.push(tasks...)
+	return nil
+}
+
+// push adds tasks to a queue.
+func (w *worker) push(tasks ...*task) {
+	tasks, size := w.encode(tasks)
+
 	w.Lock()
 	defer w.Unlock()
+	if w.coalesce {
+		tasks, size = w.fold(tasks, size)
+	}
+	w.queue = append(w.queue, tasks...)
+	w.queueBytes += size
+}
 
-	w.queue = append(w.queue, act...)
-	return nil
+// fold coalesces tasks into queued tasks on the same documents, which are not sent yet.
+// it returns tasks which could not be folded with their bytes, and it should be called with a lock.
+func (w *worker) fold(tasks []*task, size int) ([]*task, int) {
+	if w.pending == nil {
+		w.pending = make(map[string]*task)
+	}
+
+	unfolded := make([]*task, 0, len(tasks))
+	for _, t := range tasks {
+		key := documentKey(t.act)
+		if key == "" {
+			unfolded = append(unfolded, t)
+			continue
+		}
+		if prev, ok := w.pending[key]; ok {
+			if act, ok := coalesce(prev.act, t.act); ok {
+				lines, actSize := t.lines, t.size
+				var err error
+				if act != t.act && w.maxBulkBytes > 0 {
+					lines, err = w.esClient.Encode(act)
+					actSize = len(lines)
+				}
+				if err == nil {
+					size += actSize - prev.size - t.size
+					// the document might not exist before a write which a delete absorbs.
+					prev.absorbed = act.GetOperation() == ES_DELETE && (prev.absorbed || writesDoc(prev.act))
+					prev.act, prev.lines, prev.size = act, lines, actSize
+					prev.folded = append(prev.folded, t)
+					w.metrics.observeCoalesce(1)
+					continue
+				}
+			}
+		}
+		w.pending[key] = t
+		unfolded = append(unfolded, t)
+	}
+	return unfolded, size
+}
+
+// unpend removes dequeued tasks from tasks into which an action could be folded, and it should be called with a lock.
+func (w *worker) unpend(tasks []*task) {
+	if len(w.pending) == 0 {
+		return
+	}
+	for _, t := range tasks {
+		if key := documentKey(t.act); w.pending[key] == t {
+			delete(w.pending, key)
+		}
+	}
+}
+
+// requeue puts tasks to be retried in front of a queue.
+func (w *worker) requeue(tasks ...*task) {
+	size := sizeOf(tasks)
+
+	w.Lock()
+	defer w.Unlock()
+	w.queue = prepend(tasks, w.queue)
+	w.queueBytes += size
+}
+
+// prepend returns a new slice of tasks followed by a queue.
+// tasks could share a backing array with other batches, so they are never appended to in place.
+func prepend(tasks, queue []*task) []*task {
+	merged := make([]*task, 0, len(tasks)+len(queue))
+	merged = append(merged, tasks...)
+	return append(merged, queue...)
+}
+
+// schedule puts tasks to be retried in front of a queue, and each of them is ready after its backoff.
+// a worker keeps sending other tasks while they are waiting.
+func (w *worker) schedule(tasks []*task) {
+	if len(tasks) == 0 {
+		return
+	}
+	w.metrics.observeRetry(len(tasks))
+
+	size := sizeOf(tasks)
+	now := time.Now()
+
+	w.Lock()
+	defer w.Unlock()
+	for _, t := range tasks {
+		t.readyAt = now.Add(w.retry.backoff(t.attempts))
+		if w.delayed == 0 || t.readyAt.Before(w.retryAt) {
+			w.retryAt = t.readyAt
+		}
+		w.delayed++
+	}
+	w.queue = prepend(tasks, w.queue)
+	w.queueBytes += size
+}
+
+// encode serializes tasks in advance if a bulk is limited by bytes, and returns them with their total bytes.
+// serialized lines are written to a bulk body as they are, so an action is serialized only once.
+// a task which could not be serialized is failed alone, and it is not returned.
+func (w *worker) encode(tasks []*task) ([]*task, int) {
+	if w.maxBulkBytes <= 0 {
+		return tasks, 0
+	}
+
+	failed := 0
+	for _, t := range tasks {
+		if t.lines != nil {
+			continue
+		}
+		lines, err := w.esClient.Encode(t.act)
+		if err != nil {
+			w.errorHandler(err)
+			w.bury(t, 0, ESResponseError{Type: encodeErrorType, Reason: err.Error()})
+			w.finish(t, nil, err)
+			failed++
+			continue
+		}
+		t.lines = lines
+		t.size = len(lines)
+	}
+
+	if failed > 0 {
+		encoded := make([]*task, 0, len(tasks)-failed)
+		for _, t := range tasks {
+			if t.lines != nil {
+				encoded = append(encoded, t)
+			}
+		}
+		tasks = encoded
+	}
+	return tasks, sizeOf(tasks)
+}
+
+// sizeOf returns total serialized bytes of tasks.
+func sizeOf(tasks []*task) int {
+	total := 0
+	for _, t := range tasks {
+		total += t.size
+	}
+	return total
+}
+
+// batchSize returns a count of actions which makes a worker send a bulk.
+func (w *worker) batchSize() int {
+	if w.adaptive != nil {
+		return w.adaptive.batchSize()
+	}
+	return w.maxQueueSize
+}
+
+// flushInterval returns a wait time to send queued actions when no event.
+func (w *worker) flushInterval() time.Duration {
+	if w.adaptive != nil {
+		return w.adaptive.flushInterval()
+	}
+	return w.waitInterval
+}
+
+// queueFull returns whether a queue exceeds a threshold of count or bytes.
+func (w *worker) queueFull() bool {
+	size := w.batchSize()
+
+	w.RLock()
+	defer w.RUnlock()
+	return len(w.queue) >= size || (w.maxBulkBytes > 0 && w.queueBytes >= w.maxBulkBytes)
+}
+
+// split divides tasks into batches not exceeding a limit of bytes and a batch size.
+// an action larger than the limit is sent alone.
+func (w *worker) split(tasks []*task) [][]*task {
+	count := w.batchSize()
+	if w.maxBulkBytes <= 0 && count <= 0 {
+		return [][]*task{tasks}
+	}
+
+	var batches [][]*task
+	start, size := 0, 0
+	for i, t := range tasks {
+		overBytes := w.maxBulkBytes > 0 && size+t.size > w.maxBulkBytes
+		overCount := count > 0 && i-start >= count
+		if i > start && (overBytes || overCount) {
+			// a capacity of a batch is limited, so appending to it doesn't overwrite the next batch.
+			batches = append(batches, tasks[start:i:i])
+			start, size = i, 0
+		}
+		size += t.size
+	}
+	return append(batches, tasks[start:])
 }
 
 // dequeueAll is to pop all of the queue data.
-func (w *worker) dequeueAll() {
+func (w *worker) dequeueAll() []*task {
 	w.Lock()
 	defer w.Unlock()
-	w.queue = w.queue[:0]
+	tasks := w.queue
+	w.queue = make([]*task, 0, w.maxQueueSize)
+	w.queueBytes = 0
+	w.delayed = 0
+	w.pending = nil
+	return tasks
+}
+
+// dequeueReady pops tasks which are ready to be sent, and tasks waiting for a retry remain in a queue.
+// in a partitioning mode, a task waits together if an earlier task with the same key is waiting for a retry.
+func (w *worker) dequeueReady() []*task {
+	w.Lock()
+	defer w.Unlock()
+	if w.delayed == 0 {
+		tasks := w.queue
+		w.queue = make([]*task, 0, w.maxQueueSize)
+		w.queueBytes = 0
+		w.pending = nil
+		return tasks
+	}
+
+	now := time.Now()
+	tasks := make([]*task, 0, len(w.queue))
+	waiting := make([]*task, 0, w.delayed)
+	w.queueBytes = 0
+	var blocked map[string]time.Time
+	if w.partition != nil {
+		blocked = make(map[string]time.Time, w.delayed)
+	}
+	for _, t := range w.queue {
+		key := ""
+		if blocked != nil {
+			key = w.partition(t.act)
+		}
+		if at, ok := blocked[key]; ok && t.readyAt.Before(at) {
+			t.readyAt = at
+		}
+		if t.readyAt.After(now) {
+			if key != "" {
+				blocked[key] = t.readyAt
+			}
+			if len(waiting) == 0 || t.readyAt.Before(w.retryAt) {
+				w.retryAt = t.readyAt
+			}
+			waiting = append(waiting, t)
+			w.queueBytes += t.size
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	w.queue = waiting
+	w.delayed = len(waiting)
+	w.unpend(tasks)
+	return tasks
+}
+
+// readyDelay returns how long it takes until any task in a queue is ready to be sent.
+func (w *worker) readyDelay() time.Duration {
+	w.RLock()
+	defer w.RUnlock()
+	if w.delayed == 0 || len(w.queue) > w.delayed {
+		return 0
+	}
+	return time.Until(w.retryAt)
+}
+
+// nextWait returns how long a loop waits before processing a queue.
+// it is shorter than the wait interval if a task waiting for a retry is ready earlier.
+func (w *worker) nextWait() time.Duration {
+	interval := w.flushInterval()
+
+	w.RLock()
+	defer w.RUnlock()
+	if w.delayed > 0 {
+		if delay := time.Until(w.retryAt); delay < interval {
+			return delay
+		}
+	}
+	return interval
 }
 
 // queueSize returns queue size.
@@ -96,28 +468,178 @@ func (w *worker) queueSize() int {
 
 // process is something that total actions get from a queue, processing its actions, respectively.
 func (w *worker) process() (err error) {
-	if w.queueSize() == 0 {
-		return nil
+	for {
+		tasks := w.dequeueReady()
+		if len(tasks) == 0 {
+			return err
+		}
+
+		// an oversized request is split before sending.
+		// in a partitioning mode, the rest of batches is put back to be dequeued again after the first batch,
+		// so a task retried in the first batch isn't overtaken by a later task with the same key.
+		batches := w.split(tasks)
+		rest := w.partition != nil && len(batches) > 1
+		if rest {
+			w.requeue(tasks[len(batches[0]):]...)
+			batches = batches[:1]
+		}
+		for _, batch := range batches {
+			if suberr := w.send(batch); suberr != nil {
+				if err != nil {
+					w.errorHandler(err)
+				}
+				err = suberr
+			}
+		}
+		if !rest || w.context().Err() != nil {
+			return err
+		}
 	}
+}
 
-	defer func() {
-		w.dequeueAll()
-	}()
+// send requests a bulk of tasks, and retries or finishes them depending on the result.
+func (w *worker) send(tasks []*task) error {
+	acts := make([]Action, 0, len(tasks))
+	for _, t := range tasks {
+		t.attempts++
+		acts = append(acts, t.act)
+	}
+
+	// actions serialized in advance are not serialized again.
+	body := acts
+	if w.maxBulkBytes > 0 {
+		body = make([]Action, 0, len(tasks))
+		for _, t := range tasks {
+			body = append(body, &encodedAction{Action: t.act, lines: t.lines})
+		}
+	}
 
 	// set request timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	parent := w.context()
+	ctx, cancel := context.WithTimeout(parent, defaultBulkTimeout)
 	defer cancel()
 
-	resp, err := w.esClient.Bulk(ctx, w.queue)
+	if w.beforeBulk != nil {
+		w.beforeBulk(ctx, w.id, acts)
+	}
+	start := time.Now()
+	resp, err := w.esClient.Bulk(ctx, body)
+	// latency doesn't include time of a hook, so a slow hook isn't regarded as congestion.
+	latency := time.Since(start)
+	if w.afterBulk != nil {
+		w.afterBulk(ctx, w.id, acts, resp, err)
+	}
 	if err != nil {
+		w.metrics.observeBulk(latency, 0, err)
+		// a bulk aborted by shutdown is not regarded as a failure, and its actions remain undelivered.
+		if parent.Err() != nil {
+			for _, t := range tasks {
+				t.attempts--
+			}
+			w.requeue(tasks...)
+			return err
+		}
+		// a failed request is regarded as congestion.
+		w.adaptive.observe(len(tasks), latency, 0, true)
+
+		// a whole request is retried depending on its http status.
+		status := RetryStatusNoResponse
+		reqErr := &BulkRequestError{}
+		if errors.As(err, &reqErr) {
+			status = reqErr.Status
+		}
+		var retries []*task
+		for _, t := range tasks {
+			if w.retry.retryable(t.attempts, status) {
+				retries = append(retries, t)
+				continue
+			}
+			w.bury(t, status, ESResponseError{Type: bulkRequestErrorType, Reason: err.Error()})
+			w.finish(t, nil, err)
+		}
+		w.schedule(retries)
 		return err
 	}
 
-	success, fail := resp.Count()
-	fmt.Printf("[go-esworker-process] success %d, fail %d \n", success, fail)
-	if fail == 0 {
-		return nil
-	} else {
+	w.metrics.observeBulk(latency, resp.bytes, nil)
+	w.adaptive.observe(len(tasks), latency, resp.bytes, resp.rejected())
+
+	// items are matched back to their actions by position.
+	// if it is impossible, none of items could be retried or handed over to a dead letter sink.
+	matched := len(resp.Items) == len(tasks)
+	bulkErr := &BulkError{}
+	var retries []*task
+	for i, t := range tasks {
+		if !matched {
+			w.finish(t, nil, fmt.Errorf("[err] process (bulk items are not matched with actions)"))
+			continue
+		}
+
+		_, status := resp.Items[i].result()
+		switch {
+		case status == nil:
+			w.finish(t, nil, fmt.Errorf("[err] process (empty bulk item)"))
+		case status.Status <= 299, t.absorbed && status.Status == 404:
+			w.finish(t, status, nil)
+		case w.retry.retryable(t.attempts, status.Status):
+			retries = append(retries, t)
+		default:
+			itemErr := newBulkItemError(t.act.GetOperation(), status, t.act)
+			bulkErr.Items = append(bulkErr.Items, itemErr)
+			w.bury(t, status.Status, status.Error)
+			w.finish(t, status, itemErr)
+		}
+	}
+	w.schedule(retries)
+	if !matched {
 		return resp.ResultError()
 	}
+	if len(bulkErr.Items) > 0 {
+		return bulkErr
+	}
+	return nil
+}
+
+// context returns a context of the worker.
+func (w *worker) context() context.Context {
+	if w.ctx == nil {
+		return context.Background()
+	}
+	return w.ctx
+}
+
+// finish is called when a task is no longer processed.
+// it is acknowledged to a write-ahead log, and its result is resolved.
+// tasks folded into it are finished with the same result.
+func (w *worker) finish(t *task, status *ESResponseStatus, err error) {
+	if err != nil {
+		atomic.AddInt64(&w.failed, int64(1+len(t.folded)))
+	} else {
+		atomic.AddInt64(&w.succeeded, int64(1+len(t.folded)))
+	}
+	w.metrics.observeItem(t.act, status, err)
+	w.wal.ack(t.seq)
+	t.result.resolve(status, err)
+	for _, f := range t.folded {
+		w.metrics.observeItem(f.act, status, err)
+		w.wal.ack(f.seq)
+		f.result.resolve(status, err)
+	}
+}
+
+// bury hands a permanently failed task over to a dead letter sink.
+func (w *worker) bury(t *task, status int, e ESResponseError) {
+	if w.deadLetter == nil {
+		return
+	}
+	letter := &DeadLetter{
+		Action:    t.act,
+		Status:    status,
+		Error:     e,
+		Attempts:  t.attempts,
+		Timestamp: time.Now(),
+	}
+	if err := w.deadLetter.Put(letter); err != nil {
+		w.errorHandler(err)
+	}
 }
@@ -2,9 +2,12 @@ package esworker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/testcontainers/testcontainers-go"
+	"net/http"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +16,651 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+type mockProxy struct {
+	sync.Mutex
+	calls [][]Action
+	delay time.Duration
+	bulk  func(call int, acts []Action) (*ESResponseBulk, error)
+}
+
+func (mp *mockProxy) Bulk(ctx context.Context, acts []Action) (*ESResponseBulk, error) {
+	mp.Lock()
+	call := len(mp.calls)
+	mp.calls = append(mp.calls, acts)
+	delay := mp.delay
+	mp.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return mp.bulk(call, acts)
+}
+
+// Encode returns 100 bytes per action and the length of its id, and it is failed if an index is "invalid".
+func (mp *mockProxy) Encode(act Action) ([]byte, error) {
+	if act.GetIndex() == "invalid" {
+		return nil, fmt.Errorf("invalid")
+	}
+	return make([]byte, 100+len(act.GetID())), nil
+}
+
+func (mp *mockProxy) callCount() int {
+	mp.Lock()
+	defer mp.Unlock()
+	return len(mp.calls)
+}
+
+// mockBulkResponse returns a response which is failed with statuses in order and others succeed.
+func mockBulkResponse(acts []Action, statuses ...int) *ESResponseBulk {
+	resp := &ESResponseBulk{}
+	for i, act := range acts {
+		status := ESResponseStatus{Id: act.GetID(), Status: 201}
+		if i < len(statuses) {
+			status.Status = statuses[i]
+			if status.Status > 299 {
+				resp.Errors = true
+				status.Error = ESResponseError{Type: "mock_exception", Reason: "mock"}
+			}
+		}
+		item := ESResponseItem{}
+		switch act.GetOperation() {
+		case ES_CREATE:
+			item.Create = status
+		case ES_UPDATE:
+			item.Update = status
+		case ES_DELETE:
+			item.Delete = status
+		default:
+			item.Index = status
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return resp
+}
+
+func testWorker(proxy ESProxy) *worker {
+	return &worker{
+		esClient:     proxy,
+		id:           1,
+		pool:         make(chan chan []*task, 1),
+		pipe:         make(chan []*task),
+		maxQueueSize: 10,
+		waitInterval: 1 * time.Second,
+		errorHandler: func(err error) { fmt.Println(err) },
+		retry: &retrier{
+			maxAttempts:     3,
+			initialInterval: time.Millisecond,
+			maxInterval:     10 * time.Millisecond,
+			multiplier:      2,
+			policy:          defaultRetryPolicy,
+		},
+		quit: make(chan bool),
+	}
+}
+
+func TestWorker_Retry(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{}
+	proxy.bulk = func(call int, acts []Action) (*ESResponseBulk, error) {
+		switch call {
+		case 0:
+			return mockBulkResponse(acts, 201, 429, 400, 503), nil
+		case 1:
+			return mockBulkResponse(acts, 429, 201), nil
+		default:
+			return mockBulkResponse(acts, 429), nil
+		}
+	}
+	w := testWorker(proxy)
+
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "2"},
+		&mockAction{op: ES_CREATE, index: "allan", id: "3"},
+		&mockAction{op: ES_DELETE, index: "allan", id: "4"},
+	))
+
+	// only retryable items are requeued.
+	err := w.process()
+	assert.Error(err)
+	assert.Equal(2, w.queueSize())
+	assert.Equal("2", w.queue[0].act.GetID())
+	assert.Equal("4", w.queue[1].act.GetID())
+
+	// requeued items are in front of a queue, and they are sent after backoff.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "5"}))
+	time.Sleep(20 * time.Millisecond)
+	err = w.process()
+	assert.NoError(err)
+	assert.Equal(1, w.queueSize())
+	assert.Equal("2", w.queue[0].act.GetID())
+	assert.Equal(2, w.queue[0].attempts)
+
+	// exhausted
+	time.Sleep(20 * time.Millisecond)
+	err = w.process()
+	assert.Error(err)
+	assert.Equal(0, w.queueSize())
+	assert.Equal(3, proxy.callCount())
+	assert.Len(proxy.calls[1], 3)
+	assert.Len(proxy.calls[2], 1)
+}
+
+func TestWorker_RetryRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{}
+	proxy.bulk = func(call int, acts []Action) (*ESResponseBulk, error) {
+		switch call {
+		case 0:
+			return nil, fmt.Errorf("connection refused")
+		case 1:
+			return nil, &BulkRequestError{Status: 429, Body: "circuit_breaking_exception"}
+		case 2:
+			return nil, &BulkRequestError{Status: 400, Body: "bad request"}
+		default:
+			return mockBulkResponse(acts), nil
+		}
+	}
+	w := testWorker(proxy)
+	w.retry.initialInterval = time.Hour
+	w.retry.maxInterval = time.Hour
+
+	// a whole request without a response is retried without blocking a worker.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "1"}))
+	start := time.Now()
+	assert.Error(w.process())
+	assert.True(time.Since(start) < time.Second)
+	assert.Equal(1, w.queueSize())
+	assert.True(w.nextWait() <= w.waitInterval)
+	assert.True(w.readyDelay() > time.Minute)
+
+	// other actions are sent while a retry is waiting.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "2"}))
+	assert.Equal(time.Duration(0), w.readyDelay())
+	assert.Error(w.process())
+	assert.Len(proxy.calls[1], 1)
+	assert.Equal("2", proxy.calls[1][0].GetID())
+	assert.Equal(2, w.queueSize())
+
+	// a status of a whole request is decided by a policy.
+	w.retry.initialInterval = time.Millisecond
+	w.retry.maxInterval = time.Millisecond
+	for _, t := range w.queue {
+		t.readyAt = time.Time{}
+	}
+	w.delayed = 0
+	assert.Error(w.process())
+	assert.Equal(0, w.queueSize())
+	assert.Equal(3, proxy.callCount())
+}
+
+func TestWorker_DeadLetter(t *testing.T) {
+	assert := assert.New(t)
+
+	sink, err := NewMemoryDeadLetterSink(10)
+	assert.NoError(err)
+
+	proxy := &mockProxy{}
+	proxy.bulk = func(call int, acts []Action) (*ESResponseBulk, error) {
+		switch call {
+		case 0:
+			return mockBulkResponse(acts, 201, 400, 409), nil
+		default:
+			return nil, &BulkRequestError{Status: 413, Body: "too large"}
+		}
+	}
+	w := testWorker(proxy)
+	w.deadLetter = sink
+
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "2"},
+		&mockAction{op: ES_CREATE, index: "allan", id: "3"},
+	))
+	assert.Error(w.process())
+	assert.Equal(2, sink.Len())
+	assert.Equal("2", sink.Letters()[0].Action.GetID())
+	assert.Equal(400, sink.Letters()[0].Status)
+	assert.Equal("mock_exception", sink.Letters()[0].Error.Type)
+	assert.Equal("3", sink.Letters()[1].Action.GetID())
+	assert.Equal(1, sink.Letters()[1].Attempts)
+
+	// a whole bulk request is failed.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "4"}))
+	assert.Error(w.process())
+	assert.Equal(3, sink.Len())
+	assert.Equal(413, sink.Letters()[2].Status)
+	assert.Equal("bulk_request_exception", sink.Letters()[2].Error.Type)
+}
+
+func TestWorker_Result(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{}
+	proxy.bulk = func(call int, acts []Action) (*ESResponseBulk, error) {
+		switch call {
+		case 0:
+			return mockBulkResponse(acts, 201, 429, 400), nil
+		case 1:
+			return mockBulkResponse(acts, 200), nil
+		default:
+			return nil, &BulkRequestError{Status: 400, Body: "bad request"}
+		}
+	}
+	w := testWorker(proxy)
+
+	var results []*ActionResult
+	for i := 0; i < 3; i++ {
+		result := newActionResult()
+		w.push(&task{act: &mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}, result: result})
+		results = append(results, result)
+	}
+
+	perr := w.process()
+	bulkErr := &BulkError{}
+	assert.True(errors.As(perr, &bulkErr))
+	assert.Len(bulkErr.Items, 1)
+	assert.Equal("2", bulkErr.Items[0].ID)
+	assert.Equal("allan", bulkErr.Items[0].Action.GetIndex())
+
+	status, err := results[0].Wait(context.Background())
+	assert.NoError(err)
+	assert.Equal("0", status.Id)
+	assert.Equal(201, status.Status)
+
+	status, err = results[2].Wait(context.Background())
+	itemErr := &BulkItemError{}
+	assert.True(errors.As(err, &itemErr))
+	assert.Equal(400, status.Status)
+	assert.Equal("mock_exception", itemErr.Type)
+
+	// not resolved until retry is finished.
+	select {
+	case <-results[1].Done():
+		assert.Fail("resolved before retry")
+	default:
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(w.process())
+	status, err = results[1].Wait(context.Background())
+	assert.NoError(err)
+	assert.Equal(200, status.Status)
+
+	// a whole bulk request is failed.
+	result := newActionResult()
+	w.push(&task{act: &mockAction{op: ES_INDEX, index: "allan"}, result: result})
+	assert.Error(w.process())
+	status, err = result.Wait(context.Background())
+	assert.Error(err)
+	assert.Nil(status)
+}
+
+func TestWorker_MaxBulkBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	w := testWorker(proxy)
+	w.maxQueueSize = 100
+
+	// sizes are not measured without a limit.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "1"}))
+	assert.Equal(0, w.queue[0].size)
+	assert.False(w.queueFull())
+	w.dequeueAll()
+
+	w.maxBulkBytes = 250
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "2"},
+	))
+	assert.Equal(101, w.queue[0].size)
+	assert.False(w.queueFull())
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "33"}))
+	assert.True(w.queueFull())
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "4"}))
+
+	// split by bytes.
+	assert.NoError(w.process())
+	assert.Equal(2, proxy.callCount())
+	assert.Len(proxy.calls[0], 2)
+	assert.Len(proxy.calls[1], 2)
+	assert.False(w.queueFull())
+
+	// an action which could not be serialized is failed alone.
+	result := newActionResult()
+	w.push(&task{act: &mockAction{op: ES_INDEX, index: "invalid", id: "5"}, result: result})
+	_, err := result.Wait(context.Background())
+	assert.Error(err)
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "6"}))
+	assert.Equal(1, w.queueSize())
+	assert.NoError(w.process())
+	assert.Equal(3, proxy.callCount())
+	assert.Len(proxy.calls[2], 1)
+
+	// an action larger than the limit is sent alone.
+	tasks := []*task{{size: 300}, {size: 100}, {size: 100}, {size: 300}}
+	batches := w.split(tasks)
+	assert.Len(batches, 3)
+	assert.Len(batches[0], 1)
+	assert.Len(batches[1], 2)
+	assert.Len(batches[2], 1)
+
+	// tasks handed over at once are split by a batch size.
+	w.maxBulkBytes = 0
+	w.maxQueueSize = 2
+	assert.Len(w.split([]*task{{}, {}, {}, {}, {}}), 3)
+}
+
+func TestWorker_AbortedDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{delay: time.Second, bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	w := testWorker(proxy)
+	w.maxBulkBytes = 250
+	w.retry.initialInterval = time.Hour
+	w.retry.maxInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	w.ctx = ctx
+
+	// a task waiting for a retry remains in a queue.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "r"}))
+	retried := w.dequeueAll()
+	retried[0].attempts = 1
+	w.schedule(retried)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: id}))
+	}
+
+	// batches aborted by shutdown are put back without overwriting each other.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	assert.Error(w.process())
+	assert.Equal(2, proxy.callCount())
+
+	var ids []string
+	for _, t := range w.dequeueAll() {
+		ids = append(ids, t.act.GetID())
+	}
+	assert.ElementsMatch([]string{"a", "b", "c", "d", "r"}, ids)
+}
+
+func TestWorker_Adaptive(t *testing.T) {
+	assert := assert.New(t)
+
+	rejected := true
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		if rejected {
+			return mockBulkResponse(acts, http.StatusTooManyRequests), nil
+		}
+		return mockBulkResponse(acts), nil
+	}}
+	w := testWorker(proxy)
+	w.retry = nil
+	w.adaptive = &batchController{size: 4, minSize: 1, maxSize: 8, sizeStep: 2,
+		interval: time.Second, minInterval: 500 * time.Millisecond, maxInterval: 4 * time.Second,
+		intervalStep: 500 * time.Millisecond, targetLatency: time.Second}
+	assert.Equal(time.Second, w.nextWait())
+
+	// a queue is full by an adjusted batch size.
+	for i := 0; i < 3; i++ {
+		assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	assert.False(w.queueFull())
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "3"}))
+	assert.True(w.queueFull())
+
+	// a rejected bulk shrinks a batch size, and grows an interval.
+	assert.Error(w.process())
+	assert.Equal(2, w.batchSize())
+	assert.Equal(2*time.Second, w.nextWait())
+
+	// a queue is split by a batch size, and a fast bulk filled up to it grows it.
+	rejected = false
+	for i := 0; i < 5; i++ {
+		assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: fmt.Sprintf("%d", i)}))
+	}
+	assert.NoError(w.process())
+	assert.Equal(4, proxy.callCount())
+	assert.Len(proxy.calls[1], 2)
+	assert.Len(proxy.calls[2], 2)
+	assert.Len(proxy.calls[3], 1)
+	assert.Equal(4, w.batchSize())
+	assert.Equal(500*time.Millisecond, w.nextWait())
+
+	// a failed request is regarded as congestion.
+	proxy.bulk = func(call int, acts []Action) (*ESResponseBulk, error) {
+		return nil, &BulkRequestError{Status: http.StatusBadRequest}
+	}
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "1"}))
+	assert.Error(w.process())
+	assert.Equal(2, w.batchSize())
+	assert.Equal(time.Second, w.nextWait())
+}
+
+func TestWorker_Partition(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		if call == 0 || call == 3 {
+			return mockBulkResponse(acts, http.StatusTooManyRequests), nil
+		}
+		return mockBulkResponse(acts), nil
+	}}
+	w := testWorker(proxy)
+	w.partition = PartitionByDocument
+
+	ids := func(acts []Action) []string {
+		var ids []string
+		for _, act := range acts {
+			ids = append(ids, act.GetOperation().GetString()+":"+act.GetID())
+		}
+		return ids
+	}
+
+	// a later action waits while an earlier one with the same key is waiting for a retry.
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "2"},
+	))
+	assert.NoError(w.process())
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_DELETE, index: "allan", id: "1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "3"},
+	))
+	assert.NoError(w.process())
+	assert.Equal([]string{"index:3"}, ids(proxy.calls[1]))
+	assert.Equal(2, w.queueSize())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(w.process())
+	assert.Equal([]string{"index:1", "delete:1"}, ids(proxy.calls[2]))
+	assert.Equal(0, w.queueSize())
+
+	// a later batch isn't sent before a retried action in an earlier batch.
+	w.maxBulkBytes = 250
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "4"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "5"},
+		&mockAction{op: ES_DELETE, index: "allan", id: "4"},
+	))
+	assert.NoError(w.process())
+	assert.Equal(4, proxy.callCount())
+	assert.Equal(2, w.queueSize())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(w.process())
+	assert.Equal([]string{"index:4", "delete:4"}, ids(proxy.calls[4]))
+	assert.Equal(0, w.queueSize())
+}
+
+func TestWorker_Coalesce(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		return mockBulkResponse(acts), nil
+	}}
+	w := testWorker(proxy)
+	w.coalesce = true
+	w.maxBulkBytes = 1000
+	w.metrics = newMetrics()
+
+	// actions on the same document are folded into a queued action.
+	indexed, updated := newActionResult(), newActionResult()
+	w.push(
+		&task{act: &StandardAction{Op: ES_INDEX, Index: "allan", Id: "1", Doc: map[string]interface{}{"a": 1}}, result: indexed},
+		&task{act: &UpdateAction{Index: "allan", Id: "1", Doc: map[string]interface{}{"b": 2}}, result: updated},
+	)
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "2"},
+		&mockAction{op: ES_INDEX, index: "allan"},
+		&mockAction{op: ES_INDEX, index: "allan"},
+		&mockAction{op: ES_DELETE, index: "allan", id: "2"},
+	))
+	assert.Equal(4, w.queueSize())
+	assert.Equal(402, w.queueBytes)
+	assert.Equal(int64(2), w.metrics.snapshot().Coalesced)
+
+	assert.NoError(w.process())
+	assert.Len(proxy.calls[0], 4)
+	assert.Equal(map[string]interface{}{"a": 1, "b": 2}, proxy.calls[0][0].GetDoc())
+	assert.Equal(ES_DELETE, proxy.calls[0][1].GetOperation())
+	for _, result := range []*ActionResult{indexed, updated} {
+		status, err := result.Wait(context.Background())
+		assert.NoError(err)
+		assert.Equal(201, status.Status)
+	}
+	assert.Equal(int64(6), w.succeeded)
+	assert.Equal(int64(6), w.metrics.snapshot().Succeeded())
+
+	// an action is not folded into a sent action.
+	assert.NoError(w.enqueue(&mockAction{op: ES_DELETE, index: "allan", id: "2"}))
+	assert.Equal(1, w.queueSize())
+	assert.Equal(int64(2), w.metrics.snapshot().Coalesced)
+}
+
+func TestWorker_CoalesceDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		prev    Action
+		success bool
+	}{
+		"index":  {prev: &mockAction{op: ES_INDEX, index: "allan", id: "1"}, success: true},
+		"create": {prev: &mockAction{op: ES_CREATE, index: "allan", id: "1"}, success: true},
+		"upsert": {prev: &UpdateAction{Index: "allan", Id: "1", Doc: map[string]interface{}{"a": 1}, DocAsUpsert: true}, success: true},
+		"update": {prev: &UpdateAction{Index: "allan", Id: "1", Doc: map[string]interface{}{"a": 1}}, success: false},
+	}
+
+	for name, t := range tests {
+		sink, err := NewMemoryDeadLetterSink(10)
+		assert.NoError(err)
+		proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+			return mockBulkResponse(acts, 404), nil
+		}}
+		w := testWorker(proxy)
+		w.coalesce = true
+		w.deadLetter = sink
+
+		// a document written by a queued action might not exist before a delete absorbing it.
+		prev, deleted := newActionResult(), newActionResult()
+		w.push(
+			&task{act: t.prev, result: prev},
+			&task{act: &mockAction{op: ES_DELETE, index: "allan", id: "1"}, result: deleted},
+		)
+		err = w.process()
+		assert.Len(proxy.calls[0], 1, name)
+		assert.Equal(ES_DELETE, proxy.calls[0][0].GetOperation(), name)
+		for _, result := range []*ActionResult{prev, deleted} {
+			status, err := result.Wait(context.Background())
+			assert.Equal(404, status.Status, name)
+			assert.Equal(t.success, err == nil, name)
+		}
+		if t.success {
+			assert.NoError(err, name)
+			assert.Equal(int64(2), w.succeeded, name)
+			assert.Equal(0, sink.Len(), name)
+		} else {
+			assert.Error(err, name)
+			assert.Equal(int64(0), w.succeeded, name)
+			assert.Equal(1, sink.Len(), name)
+		}
+	}
+}
+
+func TestWorker_BulkHook(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := &mockProxy{bulk: func(call int, acts []Action) (*ESResponseBulk, error) {
+		if call == 0 {
+			return mockBulkResponse(acts, 201, 400), nil
+		}
+		return nil, fmt.Errorf("unavailable")
+	}}
+	w := testWorker(proxy)
+
+	var before, after [][]Action
+	var resps []*ESResponseBulk
+	var errs []error
+	w.beforeBulk = func(ctx context.Context, workerID int, acts []Action) {
+		assert.Equal(w.id, workerID)
+		assert.NoError(ctx.Err())
+		before = append(before, acts)
+		// nothing is sent yet.
+		assert.Equal(len(before)-1, proxy.callCount())
+	}
+	w.afterBulk = func(ctx context.Context, workerID int, acts []Action, resp *ESResponseBulk, err error) {
+		assert.Equal(w.id, workerID)
+		after = append(after, acts)
+		resps = append(resps, resp)
+		errs = append(errs, err)
+	}
+
+	// items in a response could be failed.
+	assert.NoError(w.enqueue(
+		&mockAction{op: ES_INDEX, index: "allan", id: "1"},
+		&mockAction{op: ES_INDEX, index: "allan", id: "2"},
+	))
+	assert.Error(w.process())
+	assert.Len(before, 1)
+	assert.Len(after, 1)
+	assert.Len(after[0], 2)
+	assert.NoError(errs[0])
+	success, fail := resps[0].Count()
+	assert.Equal(1, success)
+	assert.Equal(1, fail)
+
+	// a whole request is failed.
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "3"}))
+	assert.Error(w.process())
+	assert.Len(before, 2)
+	assert.Len(after, 2)
+	assert.Nil(resps[1])
+	assert.Error(errs[1])
+
+	// latency of a bulk doesn't include time of a hook.
+	w.metrics = newMetrics()
+	w.afterBulk = func(ctx context.Context, workerID int, acts []Action, resp *ESResponseBulk, err error) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NoError(w.enqueue(&mockAction{op: ES_INDEX, index: "allan", id: "4"}))
+	w.process()
+	latency := w.metrics.snapshot().BulkLatency
+	assert.Equal(uint64(1), latency.Count)
+	assert.True(latency.Sum < 0.1)
+}
+
 func TestWorker(t *testing.T) {
 	assert := assert.New(t)
 	ctx := context.Background()
@@ -37,7 +685,7 @@ func TestWorker(t *testing.T) {
 	cfg := testCfg(V6)
 
 	var workers []*worker
-	pool := make(chan chan Action, 2)
+	pool := make(chan chan []*task, 2)
 	for i := 0; i < 2; i++ {
 		escli, err := createESProxy(cfg)
 		assert.NoError(err)
@@ -45,7 +693,7 @@ func TestWorker(t *testing.T) {
 			esClient:     escli,
 			id:           1,
 			pool:         pool,
-			pipe:         make(chan Action),
+			pipe:         make(chan []*task),
 			maxQueueSize: 10,
 			waitInterval: 1 * time.Second,
 			errorHandler: func(err error) { fmt.Println(err) },